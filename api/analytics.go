@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sales_analytics/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetProductAffinity returns the strongest associations between items bought together
+func (h *Handler) GetProductAffinity(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date", "basket_key", "level", "min_support", "min_count", "sort_by", "limit")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	basketKey, err := repository.ParseBasketKey(c.Query("basket_key", h.config.AffinityBasketKey))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	level, err := repository.ParseAffinityLevel(c.Query("level", string(repository.AffinityProduct)))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	sortBy := c.Query("sort_by", repository.AffinitySortLift)
+	switch sortBy {
+	case repository.AffinitySortLift, repository.AffinitySortSupport, repository.AffinitySortConfidence:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid sort_by, use lift, support or confidence",
+		})
+	}
+
+	opts := repository.AffinityOptions{BasketKey: basketKey, Level: level, SortBy: sortBy}
+	if opts.MinSupport, err = queryFloat(c, "min_support", 0, 0, 1); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if opts.MinCount, err = queryInt(c, "min_count", 2, 1, math.MaxInt); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if opts.Limit, err = queryInt(c, "limit", 20, 1, 1000); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := h.repo.CalculateAffinity(ctx, startDate, endDate, opts, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate affinity",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date":         startDate.Format("2006-01-02"),
+		"end_date":           endDate.Format("2006-01-02"),
+		"basket_key":         opts.BasketKey,
+		"level":              opts.Level,
+		"baskets":            result.Baskets,
+		"multi_item_baskets": result.MultiItem,
+		"oversized_baskets":  result.Oversized,
+		"total_pairs":        result.TotalPairs,
+		"pairs":              result.Pairs,
+	})
+}
+
+// GetPivot aggregates orders by any combination of row and column dimensions
+func (h *Handler) GetPivot(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date", "rows", "cols", "measures")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := repository.PivotQuery{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Rows:      splitList(c.Query("rows")),
+		Cols:      splitList(c.Query("cols")),
+		Measures:  splitList(c.Query("measures", "revenue")),
+		Filter:    filter,
+	}
+	if err := query.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	pivot, err := h.repo.Pivot(ctx, query)
+	if errors.Is(err, repository.ErrPivotTooLarge) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate pivot",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.Format("2006-01-02"),
+		"pivot":      pivot,
+	})
+}
+
+// splitList splits a comma separated query value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetDiscountEffectiveness compares sales across discount bands
+func (h *Handler) GetDiscountEffectiveness(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date", "bands", "limit")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	edges := repository.DefaultDiscountBandEdges
+	if value := c.Query("bands"); value != "" {
+		edges = nil
+		for _, item := range splitList(value) {
+			edge, err := strconv.ParseFloat(item, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "bands must be a comma separated list of numbers",
+				})
+			}
+			edges = append(edges, edge)
+		}
+	}
+	bands, err := repository.NewDiscountBands(edges)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	limit, err := queryInt(c, "limit", 50, 1, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	analysis, err := h.repo.CalculateDiscountEffectiveness(ctx, startDate, endDate, bands, limit, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate discount effectiveness",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date":     startDate.Format("2006-01-02"),
+		"end_date":       endDate.Format("2006-01-02"),
+		"bands":          analysis.Bands,
+		"totals":         analysis.Totals,
+		"by_category":    analysis.ByCategory,
+		"by_product":     analysis.ByProduct,
+		"total_products": analysis.TotalProducts,
+	})
+}
+
+// GetAnomalies lists the revenue anomalies found by the detector that runs after each refresh
+func (h *Handler) GetAnomalies(c *fiber.Ctx) error {
+	filter, err := parseFilter(c, "start_date", "end_date", "dimension", "value", "direction", "limit", "offset")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// Anomalies are stored per dimension value, not per order line
+	if len(filter) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "order filters do not apply to anomalies, use dimension and value",
+		})
+	}
+
+	query := repository.AnomalyQuery{
+		Dimension: c.Query("dimension"),
+		Values:    splitList(c.Query("value")),
+		Direction: c.Query("direction"),
+	}
+	if query.Limit, err = queryInt(c, "limit", 100, 1, 1000); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if query.Offset, err = queryInt(c, "offset", 0, 0, math.MaxInt); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The date range is optional here, without it every stored anomaly is listed
+	response := fiber.Map{}
+	if c.Query("start_date") != "" || c.Query("end_date") != "" {
+		startDate, endDate, err := h.parseDateRange(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		query.StartDate, query.EndDate = startDate, endDate
+		response["start_date"] = startDate.Format("2006-01-02")
+		response["end_date"] = endDate.Format("2006-01-02")
+	}
+
+	if query.Dimension != "" && !slices.Contains(repository.AnomalyDimensions, query.Dimension) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid dimension, use region or category",
+		})
+	}
+	if query.Direction != "" && query.Direction != repository.AnomalySpike && query.Direction != repository.AnomalyDrop {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid direction, use spike or drop",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	anomalies, total, err := h.repo.GetAnomalies(ctx, query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch anomalies",
+		})
+	}
+
+	response["total"] = total
+	response["limit"] = query.Limit
+	response["offset"] = query.Offset
+	response["window_days"] = h.config.AnomalyWindowDays
+	response["threshold"] = h.config.AnomalyThreshold
+	response["anomalies"] = anomalies
+	return c.JSON(response)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sales_analytics/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateAPIKeyRequest request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyFromRequest reads the key from a bearer Authorization header or X-API-Key
+func apiKeyFromRequest(c *fiber.Ctx) string {
+	if scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(c.Get("X-API-Key"))
+}
+
+// requireScope rejects requests without an active API key granting scope. Every
+// accepted or refused use of a known key is recorded on the key.
+func (h *Handler) requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !h.config.AuthEnabled {
+			return c.Next()
+		}
+
+		key := apiKeyFromRequest(c)
+		if key == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "API key required",
+			})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+		defer cancel()
+
+		apiKey, err := h.repo.AuthenticateAPIKey(ctx, key)
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "invalid or revoked API key",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to authenticate API key",
+			})
+		}
+
+		if !apiKey.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": fmt.Sprintf("API key is missing the %s scope", scope),
+			})
+		}
+
+		c.Locals("api_key", apiKey)
+		return c.Next()
+	}
+}
+
+// CreateAPIKey creates an API key. The key is only ever returned in this response.
+func (h *Handler) CreateAPIKey(c *fiber.Ctx) error {
+	req := new(CreateAPIKeyRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request payload",
+		})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "name is required",
+		})
+	}
+
+	scopes, err := repository.ParseScopes(req.Scopes)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKey, key, err := h.repo.CreateAPIKey(ctx, name, scopes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create API key",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListAPIKeys lists every API key without the keys themselves
+func (h *Handler) ListAPIKeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	keys, err := h.repo.ListAPIKeys(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list API keys",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// RotateAPIKey issues a new key for an API key; the previous key stops working
+func (h *Handler) RotateAPIKey(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid API key id",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKey, key, err := h.repo.RotateAPIKey(ctx, id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rotate API key",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// RevokeAPIKey permanently disables an API key
+func (h *Handler) RevokeAPIKey(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid API key id",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKey, err := h.repo.RevokeAPIKey(ctx, id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke API key",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_key": apiKey,
+	})
+}
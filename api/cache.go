@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sales_analytics/pkg/export"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cacheKey identifies a response by its path and normalized query: parameters are
+// sorted, blank ones dropped, and the values of list filters, whose order does not
+// change the result, are trimmed, deduplicated and sorted. An export format picked
+// through the Accept header is keyed as if it were requested with format.
+func cacheKey(c *fiber.Ctx) string {
+	values := url.Values{}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		name := string(key)
+		if values.Has(name) {
+			return // handlers only read the first value
+		}
+		v := strings.TrimSpace(string(value))
+		if _, ok := stringFilters[name]; ok {
+			items := splitList(v)
+			slices.Sort(items)
+			v = strings.Join(slices.Compact(items), ",")
+		}
+		if v != "" {
+			values.Set(name, v)
+		}
+	})
+	if !values.Has("format") {
+		if format := export.Negotiate(c.Get(fiber.HeaderAccept)); format != export.FormatJSON {
+			values.Set("format", string(format))
+		}
+	}
+	return c.Path() + "?" + values.Encode()
+}
+
+// etag is a strong validator derived from the response body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists tag. Weak comparison
+// applies, as for any conditional GET.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers a conditional GET whose validator still matches
+func notModified(c *fiber.Ctx) error {
+	c.Response().ResetBody()
+	c.Response().Header.Del(fiber.HeaderContentType)
+	return c.SendStatus(fiber.StatusNotModified)
+}
+
+// dataVersionInterval is how long a replica trusts the data version it last read,
+// and so bounds how long it can serve results from before a refresh elsewhere
+const dataVersionInterval = 2 * time.Second
+
+// dataVersion memoizes the version of the shared data for a short interval, so the
+// cache does not query MongoDB on every request
+type dataVersion struct {
+	load     func(ctx context.Context) (string, error)
+	interval time.Duration
+
+	mu        sync.Mutex
+	value     string
+	checkedAt time.Time
+}
+
+// get returns the data version, reading it again once interval has passed
+func (v *dataVersion) get(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.checkedAt.IsZero() && time.Since(v.checkedAt) < v.interval {
+		return v.value, nil
+	}
+	value, err := v.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	v.value, v.checkedAt = value, time.Now()
+	return value, nil
+}
+
+// syncCache purges the response cache when a refresh on any replica has changed the
+// data since the cache was filled. It reports whether the cache can be used; while
+// the data version cannot be read, responses are neither served from nor stored in it.
+func (h *Handler) syncCache(c *fiber.Ctx) bool {
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	version, err := h.version.get(ctx)
+	if err != nil {
+		log.Printf("Response cache bypassed, failed to read the data version: %v", err)
+		return false
+	}
+	h.cache.Sync(version)
+	return true
+}
+
+// cacheResponses serves repeated analytics queries from the response cache and
+// answers conditional GETs with 304. Cached entries live until CACHE_TTL expires or
+// the data version changes after a refresh on any replica. Clients must revalidate
+// before reusing a response, so a refresh is visible on their next request.
+func (h *Handler) cacheResponses(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodGet {
+		return c.Next()
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	c.Set(fiber.HeaderVary, fiber.HeaderAccept)
+
+	key := cacheKey(c)
+	useCache := h.cache != nil && h.syncCache(c)
+	if useCache {
+		if entry, ok := h.cache.Get(key); ok {
+			c.Set(fiber.HeaderETag, entry.ETag)
+			c.Set(fiber.HeaderAge, fmt.Sprint(int(time.Since(entry.StoredAt).Seconds())))
+			c.Set("X-Cache", "HIT")
+			if etagMatches(c.Get(fiber.HeaderIfNoneMatch), entry.ETag) {
+				return notModified(c)
+			}
+			c.Set(fiber.HeaderContentType, entry.ContentType)
+			return c.Send(entry.Body)
+		}
+	}
+
+	var generation uint64
+	if useCache {
+		generation = h.cache.Generation()
+	}
+
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() != fiber.StatusOK {
+		c.Response().Header.Del(fiber.HeaderCacheControl)
+		return nil
+	}
+	if c.Response().IsBodyStream() {
+		return nil // exports are written by a body stream writer and are not cached
+	}
+
+	body := slices.Clone(c.Response().Body())
+	tag := etag(body)
+	c.Set(fiber.HeaderETag, tag)
+	if useCache {
+		c.Set("X-Cache", "MISS")
+		h.cache.Set(key, generation, body, string(c.Response().Header.ContentType()), tag)
+	}
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), tag) {
+		return notModified(c)
+	}
+	return nil
+}
+
+// GetCacheStats returns the counters of the response cache
+func (h *Handler) GetCacheStats(c *fiber.Ctx) error {
+	if h.cache == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+	return c.JSON(fiber.Map{
+		"enabled": true,
+		"ttl":     h.config.CacheTTL.String(),
+		"stats":   h.cache.Stats(),
+	})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"sales_analytics/pkg/cache"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sharedData stands in for MongoDB as seen by every replica: the revenue a query
+// returns and the data version published by the last refresh
+type sharedData struct {
+	mu      sync.Mutex
+	revenue string
+	version string
+	err     error
+}
+
+func (d *sharedData) refresh(revenue, version string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revenue, d.version = revenue, version
+}
+
+func (d *sharedData) loadVersion(context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.version, d.err
+}
+
+// newReplica serves the shared data through the response cache of its own handler
+func newReplica(data *sharedData) *fiber.App {
+	h := &Handler{
+		cache:   cache.New(time.Hour, 1<<20),
+		version: &dataVersion{load: data.loadVersion},
+	}
+
+	app := fiber.New()
+	app.Get("/revenue/total", h.cacheResponses, func(c *fiber.Ctx) error {
+		data.mu.Lock()
+		defer data.mu.Unlock()
+		return c.SendString(data.revenue)
+	})
+	return app
+}
+
+// get requests the cached endpoint and returns the body and X-Cache header
+func get(t *testing.T, app *fiber.App) (string, string) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/revenue/total?start_date=2024-01-01", nil))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body failed: %v", err)
+	}
+	return string(body), resp.Header.Get("X-Cache")
+}
+
+func TestCacheInvalidatedAcrossReplicas(t *testing.T) {
+	data := &sharedData{revenue: "100", version: "v1"}
+	replicaA, replicaB := newReplica(data), newReplica(data)
+
+	for name, app := range map[string]*fiber.App{"A": replicaA, "B": replicaB} {
+		if body, status := get(t, app); body != "100" || status != "MISS" {
+			t.Fatalf("replica %s first request = %s (%s), want 100 (MISS)", name, body, status)
+		}
+		if body, status := get(t, app); body != "100" || status != "HIT" {
+			t.Fatalf("replica %s second request = %s (%s), want 100 (HIT)", name, body, status)
+		}
+	}
+
+	// A refresh on replica A loads new data and publishes a new version; replica B
+	// only sees the shared state
+	data.refresh("250", "v2")
+
+	if body, status := get(t, replicaB); body != "250" || status != "MISS" {
+		t.Errorf("replica B after refresh = %s (%s), want 250 (MISS)", body, status)
+	}
+	if body, status := get(t, replicaB); body != "250" || status != "HIT" {
+		t.Errorf("replica B repeated = %s (%s), want 250 (HIT)", body, status)
+	}
+	if body, status := get(t, replicaA); body != "250" || status != "MISS" {
+		t.Errorf("replica A after refresh = %s (%s), want 250 (MISS)", body, status)
+	}
+}
+
+func TestCacheBypassedWithoutDataVersion(t *testing.T) {
+	data := &sharedData{revenue: "100", version: "v1"}
+	app := newReplica(data)
+	get(t, app)
+
+	// While the version cannot be read, a cached response may be stale
+	data.mu.Lock()
+	data.revenue, data.err = "250", errors.New("connection refused")
+	data.mu.Unlock()
+
+	for range 2 {
+		if body, status := get(t, app); body != "250" || status != "" {
+			t.Errorf("request without data version = %s (%q), want 250 without X-Cache", body, status)
+		}
+	}
+}
+
+func TestDataVersionMemoized(t *testing.T) {
+	loads := 0
+	v := &dataVersion{
+		load: func(context.Context) (string, error) {
+			loads++
+			return "v1", nil
+		},
+		interval: time.Hour,
+	}
+
+	for range 3 {
+		if version, err := v.get(context.Background()); err != nil || version != "v1" {
+			t.Fatalf("get() = %q, %v, want v1", version, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("version loaded %d times within the interval, want 1", loads)
+	}
+}
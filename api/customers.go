@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"sales_analytics/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetTopCustomers returns the customers with the highest revenue in a date range
+func (h *Handler) GetTopCustomers(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date", "limit")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	limit, err := queryInt(c, "limit", 10, 1, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	results, err := h.repo.CalculateTopCustomers(ctx, startDate, endDate, limit, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate top customers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.Format("2006-01-02"),
+		"customers":  results,
+	})
+}
+
+// GetCustomerLifetimeValues returns lifetime purchase metrics per customer
+func (h *Handler) GetCustomerLifetimeValues(c *fiber.Ctx) error {
+	filter, err := parseFilter(c, "limit", "offset")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	limit, err := queryInt(c, "limit", 50, 1, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	offset, err := queryInt(c, "offset", 0, 0, math.MaxInt)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	results, total, err := h.repo.CalculateCustomerLifetimeValues(ctx, limit, offset, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate customer lifetime values",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+		"customers": results,
+	})
+}
+
+// GetCustomerSummary returns a customer with lifetime metrics and order history
+func (h *Handler) GetCustomerSummary(c *fiber.Ctx) error {
+	limit, err := queryInt(c, "limit", 100, 1, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	offset, err := queryInt(c, "offset", 0, 0, math.MaxInt)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	summary, err := h.repo.GetCustomerSummary(ctx, c.Params("id"), limit, offset)
+	if errors.Is(err, repository.ErrCustomerNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch customer summary",
+		})
+	}
+
+	return c.JSON(summary)
+}
+
+// GetCustomerSegments returns an RFM segmentation of customers
+func (h *Handler) GetCustomerSegments(c *fiber.Ctx) error {
+	filter, err := parseFilter(c, "reference_date", "segment", "limit")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	referenceDate := time.Now().UTC()
+	if value := c.Query("reference_date"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid reference_date format, use YYYY-MM-DD",
+			})
+		}
+		referenceDate = parsed
+	}
+
+	segment := c.Query("segment")
+	if segment != "" && !slices.Contains(repository.RFMSegments, segment) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":    "unknown segment",
+			"segments": repository.RFMSegments,
+		})
+	}
+
+	limit, err := queryInt(c, "limit", 100, 0, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	analysis, err := h.repo.CalculateRFMSegments(ctx, referenceDate, limit, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate customer segments",
+		})
+	}
+
+	segments := analysis.Segments
+	if segment != "" {
+		segments = slices.DeleteFunc(segments, func(s repository.RFMSegment) bool {
+			return s.Segment != segment
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"reference_date":  analysis.ReferenceDate.Format("2006-01-02"),
+		"total_customers": analysis.TotalCustomers,
+		"counts":          analysis.Counts,
+		"segments":        segments,
+	})
+}
+
+// GetCustomerCohorts returns monthly cohort retention as a heatmap matrix
+func (h *Handler) GetCustomerCohorts(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	matrix, err := h.repo.CalculateCohortRetention(ctx, startDate, endDate, filter)
+	if errors.Is(err, repository.ErrTooManyCohorts) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate cohort retention",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.Format("2006-01-02"),
+		"filter":     filter,
+		"periods":    matrix.Periods,
+		"cohorts":    matrix.Cohorts,
+	})
+}
@@ -2,34 +2,105 @@ package api
 
 import (
 	"context"
+	"errors"
 	"log"
+	"math"
 	"time"
 
+	"sales_analytics/pkg/refresh"
 	"sales_analytics/pkg/repository"
 
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // RefreshData triggers a data refresh from CSV
 func (h *Handler) RefreshData(c *fiber.Ctx) error {
 	log.Println("Data refresh triggered")
 
-	// Create a background context with timeout
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	job, err := h.refresh.Start(ctx, "api")
+	if errors.Is(err, refresh.ErrRefreshInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  err.Error(),
+			"status": job.Status,
+			"job_id": job.ID.Hex(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start data refresh",
+		})
+	}
 
-	// Create loader and load data
-	loader := repository.NewDataLoader(h.repo, h.config.WorkerPoolSize)
+	message := "Data refresh initiated"
+	if job.Status == repository.RefreshQueued {
+		message = "Data refresh queued behind the refresh in progress"
+	}
 
-	// Run in goroutine for async processing
-	go func() {
-		if err := loader.LoadCSV(ctx, h.config.CSVFilePath); err != nil {
-			log.Printf("Data refresh failed: %v", err)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": message,
+		"status":  job.Status,
+		"job_id":  job.ID.Hex(),
+	})
+}
+
+// GetRefreshStatus returns the status and progress of a refresh job
+func (h *Handler) GetRefreshStatus(c *fiber.Ctx) error {
+	jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid refresh id",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	refreshLog, err := h.repo.GetRefreshLog(ctx, jobID)
+	if errors.Is(err, repository.ErrRefreshNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch refresh status",
+		})
+	}
+
+	return c.JSON(refreshLog)
+}
+
+// CancelRefresh cancels a running refresh job
+func (h *Handler) CancelRefresh(c *fiber.Ctx) error {
+	jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid refresh id",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.refresh.Cancel(ctx, jobID); err != nil {
+		if errors.Is(err, refresh.ErrJobNotRunning) || errors.Is(err, refresh.ErrJobCommitted) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":  err.Error(),
+				"job_id": jobID.Hex(),
+			})
 		}
-	}()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to cancel data refresh",
+		})
+	}
 
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"message": "Data refresh initiated",
-		"status":  "processing",
+		"message": "Data refresh cancellation requested",
+		"job_id":  jobID.Hex(),
 	})
 }
 
@@ -49,3 +120,90 @@ func (h *Handler) GetRefreshLogs(c *fiber.Ctx) error {
 		"logs": logs,
 	})
 }
+
+// GetRefreshRejections returns the rows rejected during a refresh
+func (h *Handler) GetRefreshRejections(c *fiber.Ctx) error {
+	refreshID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid refresh id",
+		})
+	}
+
+	limit, err := queryInt(c, "limit", 100, 1, 1000)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	offset, err := queryInt(c, "offset", 0, 0, math.MaxInt)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	rows, total, err := h.repo.GetRejectedRows(ctx, refreshID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch rejected rows",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"refresh_id": refreshID.Hex(),
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+		"rejections": rows,
+	})
+}
+
+// RebuildRollup triggers a rebuild of the daily sales rollup from scratch
+func (h *Handler) RebuildRollup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	job, err := h.refresh.RebuildRollup(ctx)
+	if errors.Is(err, refresh.ErrRefreshInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  err.Error(),
+			"status": job.Status,
+			"job_id": job.ID.Hex(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start rollup rebuild",
+		})
+	}
+
+	message := "Rollup rebuild initiated"
+	if job.Status == repository.RefreshQueued {
+		message = "Rollup rebuild queued behind the refresh in progress"
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": message,
+		"status":  job.Status,
+		"job_id":  job.ID.Hex(),
+	})
+}
+
+// GetRollupStatus returns the state of the daily sales rollup
+func (h *Handler) GetRollupStatus(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	status, err := h.repo.GetRollupStatus(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch rollup status",
+		})
+	}
+
+	return c.JSON(status)
+}
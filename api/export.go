@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"time"
+
+	"sales_analytics/pkg/export"
+	"sales_analytics/pkg/forecast"
+	"sales_analytics/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportFormat reads the response format from the format query parameter, falling
+// back to the Accept header
+func exportFormat(c *fiber.Ctx) (export.Format, error) {
+	if value := c.Query("format"); value != "" {
+		return export.ParseFormat(value)
+	}
+	return export.Negotiate(c.Get(fiber.HeaderAccept)), nil
+}
+
+// sendExport sends a table as a file download named after the export and its date
+// range. Tables longer than EXPORT_MAX_ROWS are refused with 400.
+func (h *Handler) sendExport(c *fiber.Ctx, format export.Format, name string, startDate, endDate time.Time, table export.Table) error {
+	if table.Len > h.config.ExportMaxRows {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("export has %d rows, more than the %d allowed; narrow the date range or filters, or page the results with limit and offset", table.Len, h.config.ExportMaxRows),
+		})
+	}
+
+	filename := export.Filename(name, startDate, endDate, format)
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The status is sent before the first row, so a failure can only cut the file short
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.Write(w, format, table); err != nil {
+			log.Printf("Failed to export %s: %v", filename, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("Failed to export %s: %v", filename, err)
+		}
+	})
+	return nil
+}
+
+// metricsColumns are the columns of RevenueMetrics, in JSON order
+var metricsColumns = []export.Column{
+	{Name: "total_revenue", Type: export.Float},
+	{Name: "gross_revenue", Type: export.Float},
+	{Name: "total_discount", Type: export.Float},
+	{Name: "net_revenue", Type: export.Float},
+	{Name: "shipping_revenue", Type: export.Float},
+	{Name: "quantity", Type: export.Int},
+	{Name: "order_count", Type: export.Int},
+	{Name: "average_order_value", Type: export.Float},
+}
+
+func metricsValues(m repository.RevenueMetrics) []any {
+	return []any{m.TotalRevenue, m.GrossRevenue, m.TotalDiscount, m.NetRevenue, m.ShippingRevenue, m.Quantity, m.OrderCount, m.AverageOrderValue}
+}
+
+// comparisonColumns are added when a period-over-period comparison was requested
+var comparisonColumns = []export.Column{
+	{Name: "previous_revenue", Type: export.Float},
+	{Name: "change", Type: export.Float},
+	{Name: "pct_change", Type: export.Float},
+}
+
+func comparisonValues(comparison *repository.RevenueComparison) []any {
+	if comparison == nil {
+		return []any{nil, nil, nil}
+	}
+	return []any{comparison.PreviousRevenue, comparison.Change, comparison.PercentChange}
+}
+
+// revenueTable lays out grouped revenue results: the key columns, the revenue
+// metrics, then the extra and comparison columns
+func revenueTable[T any](name string, keys, extra []export.Column, compare bool, results []T, values func(T) (key []any, metrics repository.RevenueMetrics, extra []any, comparison *repository.RevenueComparison)) export.Table {
+	columns := append(append(append([]export.Column{}, keys...), metricsColumns...), extra...)
+	if compare {
+		columns = append(columns, comparisonColumns...)
+	}
+
+	return export.Table{
+		Name:    name,
+		Columns: columns,
+		Len:     len(results),
+		Rows: func(yield func([]any) bool) {
+			for _, result := range results {
+				key, metrics, extraValues, comparison := values(result)
+				row := append(append(key, metricsValues(metrics)...), extraValues...)
+				if compare {
+					row = append(row, comparisonValues(comparison)...)
+				}
+				if !yield(row) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// totalRevenueTable is the single row of the total revenue endpoint
+func totalRevenueTable(startDate, endDate time.Time, metrics repository.RevenueMetrics, comparison *repository.RevenueComparison, compare bool) export.Table {
+	dates := []export.Column{{Name: "start_date", Type: export.Date}, {Name: "end_date", Type: export.Date}}
+	return revenueTable("Total Revenue", dates, nil, compare, []repository.RevenueMetrics{metrics},
+		func(m repository.RevenueMetrics) ([]any, repository.RevenueMetrics, []any, *repository.RevenueComparison) {
+			return []any{startDate, endDate}, m, nil, comparison
+		})
+}
+
+func productRevenueTable(results []repository.ProductRevenueResult, compare bool) export.Table {
+	keys := []export.Column{{Name: "product_id", Type: export.String}, {Name: "product_name", Type: export.String}}
+	return revenueTable("Products", keys, nil, compare, results,
+		func(r repository.ProductRevenueResult) ([]any, repository.RevenueMetrics, []any, *repository.RevenueComparison) {
+			return []any{r.ProductID, r.ProductName}, r.RevenueMetrics, nil, r.Comparison
+		})
+}
+
+func categoryRevenueTable(results []repository.CategoryRevenueResult, compare bool) export.Table {
+	keys := []export.Column{{Name: "category", Type: export.String}}
+	return revenueTable("Categories", keys, nil, compare, results,
+		func(r repository.CategoryRevenueResult) ([]any, repository.RevenueMetrics, []any, *repository.RevenueComparison) {
+			return []any{r.Category}, r.RevenueMetrics, nil, r.Comparison
+		})
+}
+
+func regionRevenueTable(results []repository.RegionRevenueResult, compare bool) export.Table {
+	keys := []export.Column{{Name: "region", Type: export.String}}
+	return revenueTable("Regions", keys, nil, compare, results,
+		func(r repository.RegionRevenueResult) ([]any, repository.RevenueMetrics, []any, *repository.RevenueComparison) {
+			return []any{r.Region}, r.RevenueMetrics, nil, r.Comparison
+		})
+}
+
+func paymentMethodRevenueTable(results []repository.PaymentMethodRevenueResult, compare bool) export.Table {
+	keys := []export.Column{{Name: "payment_method", Type: export.String}}
+	extra := []export.Column{{Name: "share", Type: export.Float}}
+	return revenueTable("Payment Methods", keys, extra, compare, results,
+		func(r repository.PaymentMethodRevenueResult) ([]any, repository.RevenueMetrics, []any, *repository.RevenueComparison) {
+			return []any{r.PaymentMethod}, r.RevenueMetrics, []any{r.Share}, r.Comparison
+		})
+}
+
+// paymentMethodShareTable lists the share series one row per method and bucket
+func paymentMethodShareTable(series []repository.PaymentMethodShareSeries) export.Table {
+	rows := 0
+	for _, s := range series {
+		rows += len(s.Points)
+	}
+
+	return export.Table{
+		Name: "Payment Method Share",
+		Len:  rows,
+		Columns: []export.Column{
+			{Name: "payment_method", Type: export.String},
+			{Name: "period_start", Type: export.Date},
+			{Name: "revenue", Type: export.Float},
+			{Name: "order_count", Type: export.Int},
+			{Name: "share", Type: export.Float},
+		},
+		Rows: func(yield func([]any) bool) {
+			for _, s := range series {
+				for _, p := range s.Points {
+					if !yield([]any{s.PaymentMethod, p.PeriodStart, p.Revenue, p.OrderCount, p.Share}) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// timeSeriesTable lists a revenue time series one row per group and bucket
+func timeSeriesTable(series []repository.RevenueSeries, groupBy string) export.Table {
+	var columns []export.Column
+	if groupBy != "" {
+		columns = append(columns, export.Column{Name: groupBy, Type: export.String})
+	}
+	if groupBy == "product" {
+		columns = append(columns, export.Column{Name: "product_name", Type: export.String})
+	}
+	columns = append(columns,
+		export.Column{Name: "period_start", Type: export.Date},
+		export.Column{Name: "total_revenue", Type: export.Float},
+	)
+
+	rows := 0
+	for _, s := range series {
+		rows += len(s.Points)
+	}
+
+	return export.Table{
+		Name:    "Revenue Time Series",
+		Columns: columns,
+		Len:     rows,
+		Rows: func(yield func([]any) bool) {
+			for _, s := range series {
+				for _, p := range s.Points {
+					var row []any
+					if groupBy != "" {
+						row = append(row, s.Group)
+					}
+					if groupBy == "product" {
+						row = append(row, s.Label)
+					}
+					if !yield(append(row, p.PeriodStart, p.TotalRevenue)) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// forecastTable lists the history followed by the forecast, one row per period
+func forecastTable(history []repository.RevenuePoint, periods []time.Time, points []forecast.Point) export.Table {
+	return export.Table{
+		Name: "Revenue Forecast",
+		Len:  len(history) + len(points),
+		Columns: []export.Column{
+			{Name: "period_start", Type: export.Date},
+			{Name: "type", Type: export.String},
+			{Name: "value", Type: export.Float},
+			{Name: "lower", Type: export.Float},
+			{Name: "upper", Type: export.Float},
+		},
+		Rows: func(yield func([]any) bool) {
+			for _, p := range history {
+				if !yield([]any{p.PeriodStart, "history", p.TotalRevenue, nil, nil}) {
+					return
+				}
+			}
+			for i, p := range points {
+				if !yield([]any{periods[i], "forecast", p.Value, p.Lower, p.Upper}) {
+					return
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sales_analytics/config"
+	"sales_analytics/pkg/export"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSendExportRowCap(t *testing.T) {
+	h := &Handler{config: &config.Config{ExportMaxRows: 2}}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	table := func(regions ...string) export.Table {
+		return export.Table{
+			Columns: []export.Column{{Name: "region", Type: export.String}},
+			Len:     len(regions),
+			Rows: func(yield func([]any) bool) {
+				for _, r := range regions {
+					if !yield([]any{r}) {
+						return
+					}
+				}
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		regions    []string
+		wantStatus int
+		wantBody   string
+	}{
+		{"within the cap", []string{"Asia", "Europe"}, fiber.StatusOK, "region\nAsia\nEurope\n"},
+		{"over the cap", []string{"Asia", "Europe", "US"}, fiber.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/export", func(c *fiber.Ctx) error {
+				return h.sendExport(c, export.FormatCSV, "regions", day, day, table(tt.regions...))
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/export", nil))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if tt.wantStatus == fiber.StatusBadRequest && resp.Header.Get(fiber.HeaderContentDisposition) != "" {
+				t.Errorf("refused export carries a Content-Disposition header")
+			}
+		})
+	}
+}
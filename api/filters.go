@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// stringFilters maps query parameters to the order fields they filter on.
+// A comma separated value matches any of the listed values.
+var stringFilters = map[string]string{
+	"region":         "region",
+	"category":       "category",
+	"product_id":     "product_id",
+	"customer_id":    "customer_id",
+	"payment_method": "payment_method",
+}
+
+// rangeFilter bounds a numeric order field with a pair of min/max parameters
+type rangeFilter struct {
+	field    string
+	min, max string
+	lower    float64 // smallest accepted value
+	upper    float64 // largest accepted value, 0 for no limit
+}
+
+var rangeFilters = []rangeFilter{
+	{field: "quantity_sold", min: "min_quantity", max: "max_quantity"},
+	{field: "discount", min: "min_discount", max: "max_discount", upper: 1},
+}
+
+// filterParams lists every query parameter understood by parseFilter
+func filterParams() []string {
+	params := make([]string, 0, len(stringFilters)+2*len(rangeFilters))
+	for param := range stringFilters {
+		params = append(params, param)
+	}
+	for _, rf := range rangeFilters {
+		params = append(params, rf.min, rf.max)
+	}
+	slices.Sort(params)
+	return params
+}
+
+// parseFilter turns the filter query parameters into the conditions of a validated
+// $match stage on orders. Parameters that are neither filters nor listed in params
+// are rejected, so a typo cannot silently widen a result.
+func parseFilter(c *fiber.Ctx, params ...string) (bson.M, error) {
+	var unknown []string
+	c.Context().QueryArgs().VisitAll(func(key, _ []byte) {
+		name := string(key)
+		if _, ok := stringFilters[name]; ok || slices.Contains(params, name) || isRangeParam(name) {
+			return
+		}
+		if !slices.Contains(unknown, name) {
+			unknown = append(unknown, name)
+		}
+	})
+	if len(unknown) > 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf(
+			"unknown query parameters: %s (filters: %s)", strings.Join(unknown, ", "), strings.Join(filterParams(), ", ")))
+	}
+
+	filter := bson.M{}
+	for param, field := range stringFilters {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		values := splitList(value)
+		switch len(values) {
+		case 0:
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must not be empty", param))
+		case 1:
+			filter[field] = values[0]
+		default:
+			filter[field] = bson.M{"$in": values}
+		}
+	}
+
+	for _, rf := range rangeFilters {
+		bounds := bson.M{}
+		lower, hasLower, err := parseBound(c, rf.min, rf)
+		if err != nil {
+			return nil, err
+		}
+		upper, hasUpper, err := parseBound(c, rf.max, rf)
+		if err != nil {
+			return nil, err
+		}
+		if hasLower && hasUpper && lower > upper {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must not be greater than %s", rf.min, rf.max))
+		}
+		if hasLower {
+			bounds["$gte"] = lower
+		}
+		if hasUpper {
+			bounds["$lte"] = upper
+		}
+		if len(bounds) > 0 {
+			filter[rf.field] = bounds
+		}
+	}
+
+	return filter, nil
+}
+
+// isRangeParam reports whether name is the min or max parameter of a range filter
+func isRangeParam(name string) bool {
+	for _, rf := range rangeFilters {
+		if name == rf.min || name == rf.max {
+			return true
+		}
+	}
+	return false
+}
+
+// queryInt reads an optional integer query parameter, def when it is absent. Values
+// that are not integers or fall outside [lower, upper] are rejected.
+func queryInt(c *fiber.Ctx, param string, def, lower, upper int) (int, error) {
+	value := c.Query(param)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < lower || n > upper {
+		if upper == math.MaxInt {
+			return 0, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be an integer of at least %d", param, lower))
+		}
+		return 0, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be an integer between %d and %d", param, lower, upper))
+	}
+	return n, nil
+}
+
+// queryFloat reads an optional numeric query parameter, def when it is absent.
+// Values that are not finite numbers or fall outside [lower, upper] are rejected.
+func queryFloat(c *fiber.Ctx, param string, def, lower, upper float64) (float64, error) {
+	value := c.Query(param)
+	if value == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < lower || f > upper {
+		return 0, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be a number between %g and %g", param, lower, upper))
+	}
+	return f, nil
+}
+
+// parseBound parses one bound of a range filter
+func parseBound(c *fiber.Ctx, param string, rf rangeFilter) (float64, bool, error) {
+	value := c.Query(param)
+	if value == "" {
+		return 0, false, nil
+	}
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(bound) || math.IsInf(bound, 0) {
+		return 0, false, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be a number", param))
+	}
+	if bound < rf.lower || (rf.upper > 0 && bound > rf.upper) {
+		if rf.upper > 0 {
+			return 0, false, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be between %g and %g", param, rf.lower, rf.upper))
+		}
+		return 0, false, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must not be negative", param))
+	}
+	return bound, true, nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sales_analytics/pkg/export"
+	"sales_analytics/pkg/forecast"
+	"sales_analytics/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// seasonLengths is the number of buckets in one seasonal cycle per granularity
+var seasonLengths = map[repository.Granularity]int{
+	repository.GranularityDay:     7,
+	repository.GranularityWeek:    52,
+	repository.GranularityMonth:   12,
+	repository.GranularityQuarter: 4,
+}
+
+// GetRevenueForecast projects net revenue for the periods following a date range
+func (h *Handler) GetRevenueForecast(c *fiber.Ctx) error {
+	startDate, endDate, err := h.parseDateRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter, err := parseFilter(c, "start_date", "end_date", "granularity", "periods", "method", "level", "season_length", "format")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	format, err := exportFormat(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	granularity, err := repository.ParseGranularity(c.Query("granularity", "month"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := granularity.CheckRange(startDate, endDate); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	method, err := forecast.ParseMethod(c.Query("method", "auto"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	periods, err := queryInt(c, "periods", 6, 1, 120)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	level, err := queryFloat(c, "level", 0.95, 0.5, 1)
+	if err == nil && level == 1 {
+		err = errors.New("level must be below 1")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	seasonLength, err := queryInt(c, "season_length", seasonLengths[granularity], 0, 366)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	series, err := h.repo.CalculateRevenueTimeSeries(ctx, startDate, endDate, granularity, "", filter)
+	if errors.Is(err, repository.ErrSeriesTooLarge) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate revenue time series",
+		})
+	}
+
+	// Partial buckets at either end of the range would read as a drop in revenue
+	history := series[0].Points
+	if len(history) > 0 && !granularity.BucketStart(startDate).Equal(startDate) {
+		history = history[1:]
+	}
+	if len(history) > 0 && granularity.Next(history[len(history)-1].PeriodStart).After(endDate.Add(time.Second)) {
+		history = history[:len(history)-1]
+	}
+
+	values := make([]float64, len(history))
+	for i, p := range history {
+		values[i] = p.TotalRevenue
+	}
+
+	result, err := forecast.Forecast(values, periods, forecast.Options{
+		Method:       method,
+		SeasonLength: seasonLength,
+		Level:        level,
+		NonNegative:  true,
+	})
+	if err != nil {
+		message := err.Error()
+		if errors.Is(err, forecast.ErrNotEnoughData) {
+			message += "; widen the date range or use a finer granularity"
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	// The forecast continues from the bucket after the last complete one
+	next := granularity.BucketStart(startDate)
+	if len(history) > 0 {
+		next = granularity.Next(history[len(history)-1].PeriodStart)
+	}
+	periodStarts := make([]time.Time, len(result.Points))
+	points := make([]fiber.Map, len(result.Points))
+	for i, p := range result.Points {
+		periodStarts[i] = next
+		points[i] = fiber.Map{
+			"period_start": next,
+			"value":        p.Value,
+			"lower":        p.Lower,
+			"upper":        p.Upper,
+		}
+		next = granularity.Next(next)
+	}
+
+	if format != export.FormatJSON {
+		return h.sendExport(c, format, "revenue_forecast", startDate, endDate, forecastTable(history, periodStarts, result.Points))
+	}
+
+	return c.JSON(fiber.Map{
+		"start_date":    startDate.Format("2006-01-02"),
+		"end_date":      endDate.Format("2006-01-02"),
+		"granularity":   granularity,
+		"level":         level,
+		"method":        result.Method,
+		"season_length": result.SeasonLength,
+		"parameters": fiber.Map{
+			"alpha": result.Alpha,
+			"beta":  result.Beta,
+			"gamma": result.Gamma,
+			"slope": result.Slope,
+		},
+		"rmse":     result.RMSE,
+		"history":  history,
+		"forecast": points,
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"sales_analytics/config"
+	"sales_analytics/pkg/cache"
+	"sales_analytics/pkg/refresh"
 	"sales_analytics/pkg/repository"
 	"sales_analytics/pkg/scheduler"
 
@@ -15,14 +17,20 @@ type Handler struct {
 	repo      *repository.MongoRepository
 	config    *config.Config
 	scheduler *scheduler.Scheduler
+	refresh   *refresh.Coordinator
+	cache     *cache.Cache // nil when response caching is disabled
+	version   *dataVersion // version of the shared data the cache is checked against
 }
 
 // NewHandler creates a new handler
-func NewHandler(repo *repository.MongoRepository, cfg *config.Config, sched *scheduler.Scheduler) *Handler {
+func NewHandler(repo *repository.MongoRepository, cfg *config.Config, sched *scheduler.Scheduler, coord *refresh.Coordinator, responses *cache.Cache) *Handler {
 	return &Handler{
 		repo:      repo,
 		config:    cfg,
 		scheduler: sched,
+		refresh:   coord,
+		cache:     responses,
+		version:   &dataVersion{load: repo.GetDataVersion, interval: dataVersionInterval},
 	}
 }
 
@@ -2,9 +2,15 @@ package api
 
 import (
 	"context"
+	"errors"
+	"math"
 	"time"
 
+	"sales_analytics/pkg/export"
+	"sales_analytics/pkg/repository"
+
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // GetTotalRevenue calculates total revenue for a date range
@@ -16,25 +22,88 @@ func (h *Handler) GetTotalRevenue(c *fiber.Ctx) error {
 		})
 	}
 
+	filter, err := parseFilter(c, "start_date", "end_date", "compare", "format")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	format, err := exportFormat(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	compare, err := parseComparison(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
 	defer cancel()
 
-	revenue, err := h.repo.CalculateTotalRevenue(ctx, startDate, endDate)
+	metrics, err := h.repo.CalculateRevenueMetrics(ctx, startDate, endDate, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to calculate total revenue",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"start_date":    startDate.Format("2006-01-02"),
-		"end_date":      endDate.Format("2006-01-02"),
-		"total_revenue": revenue,
-	})
+	response := fiber.Map{
+		"start_date":          startDate.Format("2006-01-02"),
+		"end_date":            endDate.Format("2006-01-02"),
+		"total_revenue":       metrics.TotalRevenue,
+		"gross_revenue":       metrics.GrossRevenue,
+		"total_discount":      metrics.TotalDiscount,
+		"net_revenue":         metrics.NetRevenue,
+		"shipping_revenue":    metrics.ShippingRevenue,
+		"quantity":            metrics.Quantity,
+		"order_count":         metrics.OrderCount,
+		"average_order_value": metrics.AverageOrderValue,
+	}
+
+	var comparison *repository.RevenueComparison
+	if compare != "" {
+		previousStart, previousEnd := compare.Range(startDate, endDate)
+		previous, err := h.repo.CalculateTotalRevenue(ctx, previousStart, previousEnd, filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to calculate total revenue",
+			})
+		}
+		addComparisonRange(response, compare, previousStart, previousEnd)
+		comparison = repository.NewRevenueComparison(metrics.NetRevenue, previous)
+		response["comparison"] = comparison
+	}
+
+	if format != export.FormatJSON {
+		return h.sendExport(c, format, "total_revenue", startDate, endDate, totalRevenueTable(startDate, endDate, metrics, comparison, compare != ""))
+	}
+	return c.JSON(response)
 }
 
-// GetRevenueByProduct calculates revenue grouped by product
-func (h *Handler) GetRevenueByProduct(c *fiber.Ctx) error {
+// groupedRevenue describes an endpoint reporting revenue per value of one dimension
+type groupedRevenue[T any] struct {
+	dimension string   // used in error messages, e.g. "payment method"
+	name      string   // export file name, e.g. "revenue_by_payment_method"
+	field     string   // response field holding the groups
+	params    []string // query parameters accepted on top of the common ones
+	calculate func(ctx context.Context, startDate, endDate time.Time, filter bson.M) ([]T, error)
+	table     func(results []T, compare bool) export.Table
+
+	// extend, when set, runs before the groups are paged. It may add to the response,
+	// or send a response of its own and report that it did.
+	extend func(c *fiber.Ctx, ctx context.Context, startDate, endDate time.Time, filter bson.M, format export.Format, response fiber.Map) (sent bool, err error)
+}
+
+// serveGroupedRevenue handles a grouped revenue request: it calculates the groups,
+// optionally compares them with a previous period, then pages and sends them as
+// JSON or as an export
+func serveGroupedRevenue[T any, P repository.RevenueGroup[T]](h *Handler, c *fiber.Ctx, g groupedRevenue[T]) error {
 	startDate, endDate, err := h.parseDateRange(c)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -42,26 +111,29 @@ func (h *Handler) GetRevenueByProduct(c *fiber.Ctx) error {
 		})
 	}
 
-	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
-	defer cancel()
+	params := append([]string{"start_date", "end_date", "compare", "format", "sort_by", "order", "limit", "offset"}, g.params...)
+	filter, err := parseFilter(c, params...)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	results, err := h.repo.CalculateRevenueByProduct(ctx, startDate, endDate)
+	format, err := exportFormat(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to calculate revenue by product",
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"start_date":       startDate.Format("2006-01-02"),
-		"end_date":         endDate.Format("2006-01-02"),
-		"products_revenue": results,
-	})
-}
+	page, err := parseGroupPage(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-// GetRevenueByCategory calculates revenue grouped by category
-func (h *Handler) GetRevenueByCategory(c *fiber.Ctx) error {
-	startDate, endDate, err := h.parseDateRange(c)
+	compare, err := parseComparison(c)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
@@ -71,22 +143,130 @@ func (h *Handler) GetRevenueByCategory(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
 	defer cancel()
 
-	results, err := h.repo.CalculateRevenueByCategory(ctx, startDate, endDate)
+	results, err := g.calculate(ctx, startDate, endDate, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to calculate revenue by category",
+			"error": "Failed to calculate revenue by " + g.dimension,
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"start_date":         startDate.Format("2006-01-02"),
-		"end_date":           endDate.Format("2006-01-02"),
-		"categories_revenue": results,
+	response := fiber.Map{
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.Format("2006-01-02"),
+	}
+
+	if compare != "" {
+		previousStart, previousEnd := compare.Range(startDate, endDate)
+		previous, err := g.calculate(ctx, previousStart, previousEnd, filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to calculate revenue by " + g.dimension,
+			})
+		}
+		addComparisonRange(response, compare, previousStart, previousEnd)
+		results = repository.CompareGroups[T, P](results, previous)
+	}
+
+	if g.extend != nil {
+		if sent, err := g.extend(c, ctx, startDate, endDate, filter, format, response); sent || err != nil {
+			return err
+		}
+	}
+
+	totalGroups := len(results)
+	results, others := repository.PageGroups[T, P](results, page)
+
+	if format != export.FormatJSON {
+		return h.sendExport(c, format, g.name, startDate, endDate, g.table(results, compare != ""))
+	}
+
+	addGroupPage(response, page, totalGroups, others)
+	response[g.field] = results
+	return c.JSON(response)
+}
+
+// GetRevenueByProduct calculates revenue grouped by product
+func (h *Handler) GetRevenueByProduct(c *fiber.Ctx) error {
+	return serveGroupedRevenue(h, c, groupedRevenue[repository.ProductRevenueResult]{
+		dimension: "product",
+		name:      "revenue_by_product",
+		field:     "products_revenue",
+		calculate: h.repo.CalculateRevenueByProduct,
+		table:     productRevenueTable,
+	})
+}
+
+// GetRevenueByCategory calculates revenue grouped by category
+func (h *Handler) GetRevenueByCategory(c *fiber.Ctx) error {
+	return serveGroupedRevenue(h, c, groupedRevenue[repository.CategoryRevenueResult]{
+		dimension: "category",
+		name:      "revenue_by_category",
+		field:     "categories_revenue",
+		calculate: h.repo.CalculateRevenueByCategory,
+		table:     categoryRevenueTable,
 	})
 }
 
 // GetRevenueByRegion calculates revenue grouped by region
 func (h *Handler) GetRevenueByRegion(c *fiber.Ctx) error {
+	return serveGroupedRevenue(h, c, groupedRevenue[repository.RegionRevenueResult]{
+		dimension: "region",
+		name:      "revenue_by_region",
+		field:     "regions_revenue",
+		calculate: h.repo.CalculateRevenueByRegion,
+		table:     regionRevenueTable,
+	})
+}
+
+// GetRevenueByPaymentMethod calculates revenue grouped by payment method, optionally
+// with each method's share of revenue per time bucket
+func (h *Handler) GetRevenueByPaymentMethod(c *fiber.Ctx) error {
+	var granularity repository.Granularity
+	if value := c.Query("granularity"); value != "" {
+		var err error
+		if granularity, err = repository.ParseGranularity(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return serveGroupedRevenue(h, c, groupedRevenue[repository.PaymentMethodRevenueResult]{
+		dimension: "payment method",
+		name:      "revenue_by_payment_method",
+		field:     "payment_methods_revenue",
+		params:    []string{"granularity"},
+		calculate: h.repo.CalculateRevenueByPaymentMethod,
+		table:     paymentMethodRevenueTable,
+		extend: func(c *fiber.Ctx, ctx context.Context, startDate, endDate time.Time, filter bson.M, format export.Format, response fiber.Map) (bool, error) {
+			if granularity == "" {
+				return false, nil
+			}
+
+			series, err := h.repo.CalculatePaymentMethodShare(ctx, startDate, endDate, granularity, filter)
+			if errors.Is(err, repository.ErrSeriesTooLarge) || errors.Is(err, repository.ErrPivotTooLarge) {
+				return true, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			if err != nil {
+				return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to calculate payment method share",
+				})
+			}
+			// A file holds one table, so exports with a granularity carry the share series
+			if format != export.FormatJSON {
+				return true, h.sendExport(c, format, "payment_method_share", startDate, endDate, paymentMethodShareTable(series))
+			}
+			response["granularity"] = granularity
+			response["share_series"] = series
+			return false, nil
+		},
+	})
+}
+
+// GetRevenueTimeSeries calculates revenue per time bucket, optionally split by product, category or region
+func (h *Handler) GetRevenueTimeSeries(c *fiber.Ctx) error {
 	startDate, endDate, err := h.parseDateRange(c)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -94,20 +274,64 @@ func (h *Handler) GetRevenueByRegion(c *fiber.Ctx) error {
 		})
 	}
 
+	filter, err := parseFilter(c, "start_date", "end_date", "granularity", "group_by", "format")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	format, err := exportFormat(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	granularity, err := repository.ParseGranularity(c.Query("granularity", "month"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := granularity.CheckRange(startDate, endDate); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	groupBy := c.Query("group_by")
+	if groupBy != "" && groupBy != "product" && groupBy != "category" && groupBy != "region" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid group_by, use product, category or region",
+		})
+	}
+
 	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
 	defer cancel()
 
-	results, err := h.repo.CalculateRevenueByRegion(ctx, startDate, endDate)
+	series, err := h.repo.CalculateRevenueTimeSeries(ctx, startDate, endDate, granularity, groupBy, filter)
+	if errors.Is(err, repository.ErrSeriesTooLarge) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to calculate revenue by region",
+			"error": "Failed to calculate revenue time series",
 		})
 	}
 
+	if format != export.FormatJSON {
+		return h.sendExport(c, format, "revenue_timeseries", startDate, endDate, timeSeriesTable(series, groupBy))
+	}
+
 	return c.JSON(fiber.Map{
-		"start_date":      startDate.Format("2006-01-02"),
-		"end_date":        endDate.Format("2006-01-02"),
-		"regions_revenue": results,
+		"start_date":  startDate.Format("2006-01-02"),
+		"end_date":    endDate.Format("2006-01-02"),
+		"granularity": granularity,
+		"group_by":    groupBy,
+		"series":      series,
 	})
 }
 
@@ -139,3 +363,64 @@ func (h *Handler) parseDateRange(c *fiber.Ctx) (time.Time, time.Time, error) {
 
 	return startDate, endDate, nil
 }
+
+// parseComparison reads the optional compare query parameter
+func parseComparison(c *fiber.Ctx) (repository.ComparisonMode, error) {
+	value := c.Query("compare")
+	if value == "" {
+		return "", nil
+	}
+	return repository.ParseComparisonMode(value)
+}
+
+// addComparisonRange records the comparison period in a response
+func addComparisonRange(response fiber.Map, compare repository.ComparisonMode, startDate, endDate time.Time) {
+	response["compare"] = compare
+	response["previous_start_date"] = startDate.Format("2006-01-02")
+	response["previous_end_date"] = endDate.Format("2006-01-02")
+}
+
+// parseGroupPage reads the sort_by, order, limit and offset query parameters of a
+// grouped revenue endpoint
+func parseGroupPage(c *fiber.Ctx) (repository.GroupPage, error) {
+	sortBy, err := repository.ParseGroupSort(c.Query("sort_by", string(repository.GroupSortRevenue)))
+	if err != nil {
+		return repository.GroupPage{}, err
+	}
+
+	// Names read alphabetically, figures largest first
+	page := repository.GroupPage{SortBy: sortBy, Descending: sortBy != repository.GroupSortName}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		page.Descending = false
+	case "desc":
+		page.Descending = true
+	default:
+		return repository.GroupPage{}, fiber.NewError(fiber.StatusBadRequest, "invalid order, use asc or desc")
+	}
+
+	// Every group is returned unless a limit is given
+	if page.Limit, err = queryInt(c, "limit", 0, 1, 1000); err != nil {
+		return repository.GroupPage{}, err
+	}
+	if page.Offset, err = queryInt(c, "offset", 0, 0, math.MaxInt); err != nil {
+		return repository.GroupPage{}, err
+	}
+	return page, nil
+}
+
+// addGroupPage records the page of a grouped revenue response and the totals of the
+// groups left out of it
+func addGroupPage(response fiber.Map, page repository.GroupPage, totalGroups int, others *repository.OtherGroups) {
+	order := "asc"
+	if page.Descending {
+		order = "desc"
+	}
+	response["total_groups"] = totalGroups
+	response["limit"] = page.Limit
+	response["offset"] = page.Offset
+	response["sort_by"] = page.SortBy
+	response["order"] = order
+	response["others"] = others
+}
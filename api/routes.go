@@ -2,6 +2,8 @@ package api
 
 import (
 	"sales_analytics/config"
+	"sales_analytics/pkg/cache"
+	"sales_analytics/pkg/refresh"
 	"sales_analytics/pkg/repository"
 	"sales_analytics/pkg/scheduler"
 
@@ -9,29 +11,63 @@ import (
 )
 
 // SetupRoutes configures all API routes
-func SetupRoutes(app *fiber.App, repo *repository.MongoRepository, cfg *config.Config, sched *scheduler.Scheduler) {
-	handler := NewHandler(repo, cfg, sched)
+func SetupRoutes(app *fiber.App, repo *repository.MongoRepository, cfg *config.Config, sched *scheduler.Scheduler, coord *refresh.Coordinator, responses *cache.Cache) {
+	handler := NewHandler(repo, cfg, sched, coord, responses)
 
 	// Health check
 	app.Get("/health", handler.HealthCheck)
 
+	// Every /api/v1 route requires an API key with the scope of its group
 	api := app.Group("/api/v1")
 
+	// Response cache statistics
+	api.Get("/cache", handler.requireScope(repository.ScopeAnalyticsRead), handler.GetCacheStats)
+
+	// API key management endpoints
+	keys := api.Group("/keys", handler.requireScope(repository.ScopeKeysAdmin))
+	keys.Post("/", handler.CreateAPIKey)
+	keys.Get("/", handler.ListAPIKeys)
+	keys.Post("/:id/rotate", handler.RotateAPIKey)
+	keys.Delete("/:id", handler.RevokeAPIKey)
+
 	// Data refresh endpoints
-	dataRefresh := api.Group("/data")
+	dataRefresh := api.Group("/data", handler.requireScope(repository.ScopeDataRefresh))
 	dataRefresh.Post("/refresh", handler.RefreshData)
+	dataRefresh.Get("/refresh/:id", handler.GetRefreshStatus)
+	dataRefresh.Delete("/refresh/:id", handler.CancelRefresh)
 	dataRefresh.Get("/logs", handler.GetRefreshLogs)
+	dataRefresh.Get("/refresh/:id/rejections", handler.GetRefreshRejections)
+	dataRefresh.Post("/rollup/rebuild", handler.RebuildRollup)
+	dataRefresh.Get("/rollup", handler.GetRollupStatus)
 
 	// Cron job management endpoints
-	cron := api.Group("/cron")
+	cron := api.Group("/cron", handler.requireScope(repository.ScopeCronAdmin))
 	cron.Post("/create", handler.CreateCronJob)
 	cron.Delete("/delete", handler.DeleteCronJob)
 	cron.Get("/status", handler.GetCronStatus)
 
 	// Revenue analytics endpoints
-	revenue := api.Group("/revenue")
+	revenue := api.Group("/revenue", handler.requireScope(repository.ScopeAnalyticsRead), handler.cacheResponses)
 	revenue.Get("/total", handler.GetTotalRevenue)
 	revenue.Get("/product", handler.GetRevenueByProduct)
 	revenue.Get("/category", handler.GetRevenueByCategory)
 	revenue.Get("/region", handler.GetRevenueByRegion)
+	revenue.Get("/payment-method", handler.GetRevenueByPaymentMethod)
+	revenue.Get("/timeseries", handler.GetRevenueTimeSeries)
+	revenue.Get("/forecast", handler.GetRevenueForecast)
+
+	// Customer analytics endpoints
+	customers := api.Group("/customers", handler.requireScope(repository.ScopeAnalyticsRead), handler.cacheResponses)
+	customers.Get("/top", handler.GetTopCustomers)
+	customers.Get("/lifetime", handler.GetCustomerLifetimeValues)
+	customers.Get("/segments", handler.GetCustomerSegments)
+	customers.Get("/cohorts", handler.GetCustomerCohorts)
+	customers.Get("/:id/summary", handler.GetCustomerSummary)
+
+	// Sales analytics endpoints
+	analytics := api.Group("/analytics", handler.requireScope(repository.ScopeAnalyticsRead), handler.cacheResponses)
+	analytics.Get("/affinity", handler.GetProductAffinity)
+	analytics.Get("/pivot", handler.GetPivot)
+	analytics.Get("/discounts", handler.GetDiscountEffectiveness)
+	analytics.Get("/anomalies", handler.GetAnomalies)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sales_analytics/config"
+	"sales_analytics/pkg/repository"
+)
+
+// apikey creates an API key from the command line, which is how the first
+// keys:admin key is issued before any key can call the API
+func main() {
+	name := flag.String("name", "", "name of the key (required)")
+	scopes := flag.String("scopes", strings.Join(repository.Scopes, ","), "comma-separated scopes")
+	flag.Parse()
+
+	if strings.TrimSpace(*name) == "" {
+		log.Fatal("-name is required")
+	}
+
+	var names []string
+	for _, scope := range strings.Split(*scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			names = append(names, scope)
+		}
+	}
+	granted, err := repository.ParseScopes(names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Load configuration
+	cfg := config.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	repo, err := repository.NewMongoRepository(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer repo.Disconnect(context.Background())
+
+	apiKey, key, err := repo.CreateAPIKey(ctx, strings.TrimSpace(*name), granted)
+	if err != nil {
+		log.Fatalf("Failed to create API key: %v", err)
+	}
+
+	log.Printf("Created API key %s (%s) with scopes %s", apiKey.ID.Hex(), apiKey.Name, strings.Join(apiKey.Scopes, ", "))
+	log.Println("Store the key now, it cannot be shown again:")
+	fmt.Println(key)
+}
@@ -10,17 +10,23 @@ import (
 
 	"sales_analytics/api"
 	"sales_analytics/config"
+	"sales_analytics/pkg/cache"
+	"sales_analytics/pkg/refresh"
 	"sales_analytics/pkg/repository"
 	"sales_analytics/pkg/scheduler"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize MongoDB connection
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -34,13 +40,29 @@ func main() {
 
 	log.Println("Successfully connected to MongoDB")
 
+	// Initialize refresh coordinator
+	coord := refresh.NewCoordinator(repo, cfg)
+	// Cancels refreshes still running at shutdown and waits for them before disconnecting
+	defer coord.Stop(30 * time.Second)
+
 	// Initialize Scheduler
-	sched := scheduler.NewScheduler(repo, cfg)
+	sched := scheduler.NewScheduler(coord)
 	sched.Start()
 	defer sched.Stop() // Ensures cleanup on server crash/shutdown
 
 	log.Println("Cron scheduler initialized")
 
+	// Initialize response cache. Every replica empties it when the shared data version
+	// changes; the replica that ran a refresh does so as soon as the refresh succeeds.
+	var responses *cache.Cache
+	if cfg.CacheEnabled {
+		responses = cache.New(cfg.CacheTTL, cfg.CacheMaxBytes)
+		coord.OnSuccess(func(jobID primitive.ObjectID) {
+			responses.Purge()
+			log.Printf("Response cache purged after refresh %s", jobID.Hex())
+		})
+	}
+
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		ErrorHandler: customErrorHandler,
@@ -51,7 +73,7 @@ func main() {
 	app.Use(logger.New())
 
 	// Setup routes
-	api.SetupRoutes(app, repo, cfg, sched)
+	api.SetupRoutes(app, repo, cfg, sched, coord, responses)
 
 	// Graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sales_analytics/config"
+	"sales_analytics/pkg/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migrate upgrades existing data to the current schema. It is safe to run more
+// than once; documents that are already up to date are left untouched.
+func main() {
+	// Load configuration
+	cfg := config.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	// Connecting also upgrades product and customer documents to versioned history
+	repo, err := repository.NewMongoRepository(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer repo.Disconnect(context.Background())
+
+	// Orders change from here on, analytics read them directly until the rollup is rebuilt
+	if err := repo.InvalidateDailyRollup(ctx); err != nil {
+		log.Fatalf("Failed to invalidate the daily rollup: %v", err)
+	}
+
+	log.Println("Backfilling order pricing...")
+
+	backfilled, remaining, err := repo.BackfillOrderPricing(ctx)
+	if err != nil {
+		log.Fatalf("Failed to backfill order pricing: %v", err)
+	}
+
+	log.Printf("Backfilled pricing on %d orders", backfilled)
+
+	log.Println("Rebuilding the daily rollup...")
+	status, err := repo.RebuildDailyRollup(ctx, primitive.NilObjectID)
+	if err != nil {
+		log.Fatalf("Failed to rebuild the daily rollup: %v", err)
+	}
+	log.Printf("Rebuilt the daily rollup (%d rows)", status.Rows)
+
+	if backfilled > 0 {
+		// Cached analytics on every API replica predate the backfill
+		if err := repo.BumpDataVersion(ctx, "migrate"); err != nil {
+			log.Fatalf("Failed to publish the data version: %v", err)
+		}
+	}
+	if remaining > 0 {
+		log.Printf("%d orders have no matching product version and were left unchanged", remaining)
+	}
+}
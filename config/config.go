@@ -1,22 +1,38 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	MongoURI            string
-	DatabaseName        string
-	CSVFilePath         string
-	Port                string
-	WorkerPoolSize      int
-	CronEnabled         bool
-	DefaultCronInterval string
+	MongoURI              string
+	DatabaseName          string
+	CSVFilePath           string
+	Port                  string
+	WorkerPoolSize        int
+	InsertBatchSize       int
+	CronEnabled           bool
+	DefaultCronInterval   string
+	RefreshTimeout        time.Duration
+	RefreshConflictPolicy string
+	RefreshLeaseTTL       time.Duration
+	CSVColumnAliases      map[string][]string
+	AffinityBasketKey     string
+	AnomalyWindowDays     int
+	AnomalyThreshold      float64
+	CacheEnabled          bool
+	CacheTTL              time.Duration
+	CacheMaxBytes         int64
+	AuthEnabled           bool
+	ExportMaxRows         int
 }
 
 // Load reads configuration from environment variables
@@ -33,20 +49,126 @@ func Load() *Config {
 		}
 	}
 
+	insertBatchSize := 1000
+	if ibs := os.Getenv("INSERT_BATCH_SIZE"); ibs != "" {
+		if parsed, err := strconv.Atoi(ibs); err == nil {
+			insertBatchSize = parsed
+		}
+	}
+
 	cronEnabled := true
 	if ce := os.Getenv("CRON_ENABLED"); ce == "false" {
 		cronEnabled = false
 	}
 
+	refreshTimeout := 30 * time.Minute
+	if rt := os.Getenv("REFRESH_TIMEOUT"); rt != "" {
+		if parsed, err := time.ParseDuration(rt); err == nil {
+			refreshTimeout = parsed
+		}
+	}
+
+	refreshLeaseTTL := time.Minute
+	if rl := os.Getenv("REFRESH_LEASE_TTL"); rl != "" {
+		if parsed, err := time.ParseDuration(rl); err == nil {
+			refreshLeaseTTL = parsed
+		}
+	}
+
+	anomalyWindowDays := 28
+	if aw := os.Getenv("ANOMALY_WINDOW_DAYS"); aw != "" {
+		if parsed, err := strconv.Atoi(aw); err == nil {
+			anomalyWindowDays = parsed
+		}
+	}
+
+	anomalyThreshold := 3.5
+	if at := os.Getenv("ANOMALY_THRESHOLD"); at != "" {
+		if parsed, err := strconv.ParseFloat(at, 64); err == nil {
+			anomalyThreshold = parsed
+		}
+	}
+
+	cacheEnabled := true
+	if ce := os.Getenv("CACHE_ENABLED"); ce == "false" {
+		cacheEnabled = false
+	}
+
+	cacheTTL := 10 * time.Minute
+	if ct := os.Getenv("CACHE_TTL"); ct != "" {
+		if parsed, err := time.ParseDuration(ct); err == nil {
+			cacheTTL = parsed
+		}
+	}
+
+	cacheMaxBytes := int64(64 << 20)
+	if cm := os.Getenv("CACHE_MAX_BYTES"); cm != "" {
+		if parsed, err := strconv.ParseInt(cm, 10, 64); err == nil {
+			cacheMaxBytes = parsed
+		}
+	}
+
+	authEnabled := true
+	if ae := os.Getenv("AUTH_ENABLED"); ae == "false" {
+		authEnabled = false
+	}
+
+	exportMaxRows := 100000
+	if em := os.Getenv("EXPORT_MAX_ROWS"); em != "" {
+		if parsed, err := strconv.Atoi(em); err == nil && parsed > 0 {
+			exportMaxRows = parsed
+		}
+	}
+
 	return &Config{
-		MongoURI:            getEnv("MONGODB_URI", "mongodb://localhost:27017"),
-		DatabaseName:        getEnv("DATABASE_NAME", "sales_analytics"),
-		CSVFilePath:         getEnv("CSV_FILE_PATH", "./data/sales_data.csv"),
-		Port:                getEnv("PORT", "8080"),
-		WorkerPoolSize:      workerPoolSize,
-		CronEnabled:         cronEnabled,
-		DefaultCronInterval: getEnv("DEFAULT_CRON_INTERVAL", "24h"),
+		MongoURI:              getEnv("MONGODB_URI", "mongodb://localhost:27017"),
+		DatabaseName:          getEnv("DATABASE_NAME", "sales_analytics"),
+		CSVFilePath:           getEnv("CSV_FILE_PATH", "./data/sales_data.csv"),
+		Port:                  getEnv("PORT", "8080"),
+		WorkerPoolSize:        workerPoolSize,
+		InsertBatchSize:       insertBatchSize,
+		CronEnabled:           cronEnabled,
+		DefaultCronInterval:   getEnv("DEFAULT_CRON_INTERVAL", "24h"),
+		RefreshTimeout:        refreshTimeout,
+		RefreshConflictPolicy: getEnv("REFRESH_CONFLICT_POLICY", "reject"),
+		RefreshLeaseTTL:       refreshLeaseTTL,
+		CSVColumnAliases:      parseColumnAliases(os.Getenv("CSV_COLUMN_ALIASES")),
+		AffinityBasketKey:     getEnv("AFFINITY_BASKET_KEY", "customer_day"),
+		AnomalyWindowDays:     anomalyWindowDays,
+		AnomalyThreshold:      anomalyThreshold,
+		CacheEnabled:          cacheEnabled,
+		CacheTTL:              cacheTTL,
+		CacheMaxBytes:         cacheMaxBytes,
+		AuthEnabled:           authEnabled,
+		ExportMaxRows:         exportMaxRows,
+	}
+}
+
+// Validate rejects settings that would otherwise silently fall back to a default
+func (c *Config) Validate() error {
+	if c.RefreshConflictPolicy != "reject" && c.RefreshConflictPolicy != "queue" {
+		return fmt.Errorf("invalid REFRESH_CONFLICT_POLICY %q, use reject or queue", c.RefreshConflictPolicy)
+	}
+	return nil
+}
+
+// parseColumnAliases parses extra CSV header aliases in the form
+// "order_id=Order No|OrderNumber;customer_email=E-mail"
+func parseColumnAliases(value string) map[string][]string {
+	aliases := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		field, names, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		field = strings.TrimSpace(field)
+		for _, name := range strings.Split(names, "|") {
+			if name = strings.TrimSpace(name); name != "" {
+				aliases[field] = append(aliases[field], name)
+			}
+		}
 	}
+	return aliases
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestValidateRefreshConflictPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: "reject"},
+		{policy: "queue"},
+		{policy: "queu", wantErr: true},
+		{policy: "Queue", wantErr: true},
+		{policy: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := (&Config{RefreshConflictPolicy: tt.policy}).Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with policy %q error = %v, want error %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Entry  cached response body with the metadata needed to serve it again
+type Entry struct {
+	Body        []byte
+	ContentType string
+	ETag        string
+	StoredAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// size approximates the memory held by an entry
+func (e *Entry) size(key string) int64 {
+	return int64(len(key) + len(e.Body) + len(e.ContentType) + len(e.ETag))
+}
+
+// item  entry kept in the recency list
+type item struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// Stats  cache counters since start
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Purges    int64 `json:"purges"`
+}
+
+// Cache is an in-memory LRU cache whose entries expire after a TTL. The total size
+// of the entries is bounded; the least recently used ones are evicted first.
+// Purge bumps a generation counter so that values computed before a purge are not
+// stored after it. Sync purges the cache when the version of the underlying data
+// changes.
+type Cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxBytes   int64
+	bytes      int64
+	generation uint64
+	version    string     // data version the entries were computed from
+	synced     bool       // whether version has been set
+	order      *list.List // most recently used first
+	items      map[string]*list.Element
+	stats      Stats
+}
+
+// New creates a cache holding entries for ttl, up to maxBytes in total
+func New(ttl time.Duration, maxBytes int64) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the live entry stored under key
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	it := el.Value.(*item)
+	if time.Now().After(it.entry.ExpiresAt) {
+		c.remove(el)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	c.stats.Hits++
+	return it.entry, true
+}
+
+// Generation identifies the data the cache currently holds. Read it before
+// computing a value and pass it to Set.
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set stores body under key unless the cache was purged since generation was read
+// or the entry alone exceeds the size bound. It reports whether the entry was stored.
+func (c *Cache) Set(key string, generation uint64, body []byte, contentType, etag string) bool {
+	now := time.Now()
+	entry := &Entry{
+		Body:        body,
+		ContentType: contentType,
+		ETag:        etag,
+		StoredAt:    now,
+		ExpiresAt:   now.Add(c.ttl),
+	}
+	size := entry.size(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || size > c.maxBytes {
+		return false
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+
+	c.items[key] = c.order.PushFront(&item{key: key, entry: entry, size: size})
+	c.bytes += size
+	return true
+}
+
+// Purge drops every entry
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+}
+
+// purge drops every entry; the caller holds the lock
+func (c *Cache) purge() {
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	c.generation++
+	c.stats.Purges++
+}
+
+// Sync records the version of the data the cache is filled from, purging every
+// entry when it differs from the version of the previous call. It reports whether
+// the cache was purged.
+func (c *Cache) Sync(version string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.synced && version == c.version {
+		return false
+	}
+	purge := c.synced
+	c.version, c.synced = version, true
+	if purge {
+		c.purge()
+	}
+	return purge
+}
+
+// Stats returns the current counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// remove unlinks an element; the caller holds the lock
+func (c *Cache) remove(el *list.Element) {
+	it := c.order.Remove(el).(*item)
+	delete(c.items, it.key)
+	c.bytes -= it.size
+}
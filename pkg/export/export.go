@@ -0,0 +1,131 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Format  file format of an export
+type Format string
+
+// Supported export formats
+const (
+	FormatJSON    Format = "json"
+	FormatCSV     Format = "csv"
+	FormatXLSX    Format = "xlsx"
+	FormatParquet Format = "parquet"
+)
+
+// contentTypes maps formats to their media types; the first one is sent, all are
+// accepted in Accept headers
+var contentTypes = map[Format][]string{
+	FormatJSON:    {"application/json"},
+	FormatCSV:     {"text/csv"},
+	FormatXLSX:    {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	FormatParquet: {"application/vnd.apache.parquet", "application/x-parquet"},
+}
+
+// ParseFormat validates a format name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatJSON, FormatCSV, FormatXLSX, FormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid format %q, use json, csv, xlsx or parquet", name)
+}
+
+// Negotiate picks the format of the most preferred media type of an Accept header.
+// JSON is used when the header is empty, allows anything or names no export format.
+func Negotiate(accept string) Format {
+	best, bestQ := FormatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if _, err := fmt.Sscanf(value, "%g", &q); err != nil {
+				continue
+			}
+		}
+		for format, types := range contentTypes {
+			for _, t := range types {
+				if t == mediaType && q > bestQ {
+					best, bestQ = format, q
+				}
+			}
+		}
+	}
+	return best
+}
+
+// ContentType returns the media type sent with a format
+func (f Format) ContentType() string {
+	return contentTypes[f][0]
+}
+
+// ColumnType  type of the values of a column
+type ColumnType int
+
+// Column types
+const (
+	String ColumnType = iota
+	Float
+	Int
+	Date // time.Time, exported without time of day
+)
+
+// Column  named, typed column of a table
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Table  rows to export. Rows yields one value per column: a string, float64,
+// *float64, int or time.Time matching the column type, or nil for a missing value.
+type Table struct {
+	Name    string // sheet name in spreadsheets
+	Columns []Column
+	Rows    iter.Seq[[]any]
+	Len     int // number of rows Rows yields
+}
+
+// Write encodes t to w in format f
+func Write(w io.Writer, f Format, t Table) error {
+	switch f {
+	case FormatCSV:
+		return writeCSV(w, t)
+	case FormatXLSX:
+		return writeXLSX(w, t)
+	case FormatParquet:
+		return writeParquet(w, t)
+	}
+	return fmt.Errorf("format %q is not a table format", f)
+}
+
+// Filename builds the download name of an export covering a date range
+func Filename(name string, startDate, endDate time.Time, f Format) string {
+	return fmt.Sprintf("%s_%s_%s.%s", name, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), f)
+}
+
+// value normalises a cell value to nil, string, float64, int64 or time.Time
+func value(v any) any {
+	switch x := v.(type) {
+	case *float64:
+		if x == nil {
+			return nil
+		}
+		return *x
+	case int:
+		return int64(x)
+	case time.Time:
+		return x.UTC()
+	default:
+		return v
+	}
+}
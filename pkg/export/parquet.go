@@ -0,0 +1,47 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetTypes maps column types to parquet schema metadata
+var parquetTypes = map[ColumnType]string{
+	String: "type=BYTE_ARRAY, convertedtype=UTF8",
+	Float:  "type=DOUBLE",
+	Int:    "type=INT64",
+	Date:   "type=INT32, convertedtype=DATE",
+}
+
+// writeParquet writes a parquet file with one optional column per table column.
+// Rows are buffered per row group, which the writer flushes as it fills.
+func writeParquet(w io.Writer, t Table) error {
+	schema := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", col.Name, parquetTypes[col.Type])
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return err
+	}
+
+	for row := range t.Rows {
+		record := make([]any, len(row))
+		for i, v := range row {
+			v = value(v)
+			if date, ok := v.(time.Time); ok {
+				v = int32(date.Unix() / 86400) // days since the Unix epoch
+			}
+			record[i] = v
+		}
+		if err := pw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return pw.WriteStop()
+}
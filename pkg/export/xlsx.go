@@ -0,0 +1,73 @@
+package export
+
+import (
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// writeXLSX writes a single-sheet workbook through excelize's stream writer. The
+// writer spills rows to a temporary file, but the workbook is assembled in full
+// before any of it is written, so callers bound the number of rows.
+func writeXLSX(w io.Writer, t Table) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := t.Name
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd"
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+
+	header := make([]any, len(t.Columns))
+	for i, col := range t.Columns {
+		header[i] = excelize.Cell{StyleID: bold, Value: col.Name}
+	}
+	if err := sw.SetRow("A1", header, excelize.RowOpts{}); err != nil {
+		return err
+	}
+
+	line := 2
+	for row := range t.Rows {
+		cells := make([]any, len(row))
+		for i, v := range row {
+			v = value(v)
+			if v != nil && t.Columns[i].Type == Date {
+				cells[i] = excelize.Cell{StyleID: date, Value: v}
+			} else {
+				cells[i] = v
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, line)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow(cell, cells); err != nil {
+			return err
+		}
+		line++
+	}
+
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
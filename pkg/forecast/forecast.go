@@ -0,0 +1,255 @@
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Method  forecasting method
+type Method string
+
+// Supported forecasting methods
+const (
+	MethodAuto        Method = "auto"         // Holt-Winters when there is enough history, linear otherwise
+	MethodHoltWinters Method = "holt_winters" // additive Holt-Winters with trend and seasonality
+	MethodLinear      Method = "linear"       // least squares linear trend
+)
+
+// ParseMethod validates a method name
+func ParseMethod(name string) (Method, error) {
+	switch m := Method(name); m {
+	case MethodAuto, MethodHoltWinters, MethodLinear:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid method %q, use auto, holt_winters or linear", name)
+}
+
+var (
+	// ErrNotEnoughData is returned when the series is too short for the method
+	ErrNotEnoughData = errors.New("not enough data to forecast")
+)
+
+// Options  forecast parameters
+type Options struct {
+	Method       Method
+	SeasonLength int     // periods per season, e.g. 12 for monthly data; 0 or 1 disables seasonality
+	Level        float64 // prediction interval coverage, e.g. 0.95
+	NonNegative  bool    // clamp forecasts and interval bounds at zero
+}
+
+// Point  forecast of one future period
+type Point struct {
+	Value float64 `json:"value"`
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
+// Result  forecast of the periods following a series
+type Result struct {
+	Method       Method  `json:"method"`
+	SeasonLength int     `json:"season_length,omitempty"`
+	Alpha        float64 `json:"alpha,omitempty"` // level smoothing
+	Beta         float64 `json:"beta,omitempty"`  // trend smoothing
+	Gamma        float64 `json:"gamma,omitempty"` // seasonal smoothing
+	Slope        float64 `json:"slope,omitempty"` // linear trend per period
+	RMSE         float64 `json:"rmse"`            // in-sample one-step error
+	Points       []Point `json:"points"`
+}
+
+// Forecast projects the next horizon periods of series
+func Forecast(series []float64, horizon int, opts Options) (*Result, error) {
+	if horizon < 1 {
+		return nil, errors.New("horizon must be at least 1")
+	}
+	if !(opts.Level > 0 && opts.Level < 1) { // also rejects NaN
+		return nil, errors.New("level must be between 0 and 1")
+	}
+	z := math.Sqrt2 * math.Erfinv(opts.Level)
+
+	method := opts.Method
+	if method == "" || method == MethodAuto {
+		method = MethodLinear
+		if opts.SeasonLength > 1 && len(series) >= 2*opts.SeasonLength {
+			method = MethodHoltWinters
+		}
+	}
+
+	var result *Result
+	var err error
+	switch method {
+	case MethodHoltWinters:
+		result, err = holtWinters(series, horizon, opts.SeasonLength, z)
+	case MethodLinear:
+		result, err = linear(series, horizon, z)
+	default:
+		return nil, fmt.Errorf("invalid method %q", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.NonNegative {
+		for i := range result.Points {
+			p := &result.Points[i]
+			p.Value, p.Lower, p.Upper = math.Max(p.Value, 0), math.Max(p.Lower, 0), math.Max(p.Upper, 0)
+		}
+	}
+	return result, nil
+}
+
+// linear fits a least squares line and widens the interval with the distance from
+// the fitted data
+func linear(series []float64, horizon int, z float64) (*Result, error) {
+	n := len(series)
+	if n < 2 {
+		return nil, fmt.Errorf("%w: linear needs at least 2 periods, got %d", ErrNotEnoughData, n)
+	}
+
+	var meanX, meanY float64
+	for i, y := range series {
+		meanX += float64(i)
+		meanY += y
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	var sxx, sxy float64
+	for i, y := range series {
+		dx := float64(i) - meanX
+		sxx += dx * dx
+		sxy += dx * (y - meanY)
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	var sse float64
+	for i, y := range series {
+		e := y - (intercept + slope*float64(i))
+		sse += e * e
+	}
+	sigma := 0.0
+	if n > 2 {
+		sigma = math.Sqrt(sse / float64(n-2))
+	}
+
+	result := &Result{
+		Method: MethodLinear,
+		Slope:  slope,
+		RMSE:   math.Sqrt(sse / float64(n)),
+		Points: make([]Point, horizon),
+	}
+	for h := 1; h <= horizon; h++ {
+		x := float64(n - 1 + h)
+		value := intercept + slope*x
+		dx := x - meanX
+		width := z * sigma * math.Sqrt(1+1/float64(n)+dx*dx/sxx)
+		result.Points[h-1] = Point{Value: value, Lower: value - width, Upper: value + width}
+	}
+	return result, nil
+}
+
+// holtWinters fits additive Holt-Winters, choosing the smoothing parameters that
+// minimise the one-step-ahead squared error on a grid
+func holtWinters(series []float64, horizon, m int, z float64) (*Result, error) {
+	if m < 2 {
+		return nil, errors.New("holt_winters needs a season length of at least 2")
+	}
+	if len(series) < 2*m {
+		return nil, fmt.Errorf("%w: holt_winters needs at least %d periods (two seasons), got %d", ErrNotEnoughData, 2*m, len(series))
+	}
+
+	best := math.Inf(1)
+	var bestFit *hwFit
+	for a := 1; a <= 9; a++ {
+		for b := 0; b <= 9; b++ {
+			for g := 0; g <= 9; g++ {
+				fit := fitHoltWinters(series, m, float64(a)/10, float64(b)/10, float64(g)/10)
+				if fit.sse < best {
+					best, bestFit = fit.sse, fit
+				}
+			}
+		}
+	}
+
+	fitted := len(series) - m
+	sigma := math.Sqrt(bestFit.sse / float64(fitted))
+
+	result := &Result{
+		Method:       MethodHoltWinters,
+		SeasonLength: m,
+		Alpha:        bestFit.alpha,
+		Beta:         bestFit.beta,
+		Gamma:        bestFit.gamma,
+		RMSE:         sigma,
+		Points:       make([]Point, horizon),
+	}
+
+	n := len(series)
+	for h := 1; h <= horizon; h++ {
+		season := bestFit.seasonal[n-m+(h-1)%m]
+		value := bestFit.level + float64(h)*bestFit.trend + season
+
+		// Variance of the h-step error for additive Holt-Winters
+		variance := 1.0
+		for j := 1; j < h; j++ {
+			c := bestFit.alpha * (1 + float64(j)*bestFit.beta)
+			if j%m == 0 {
+				c += bestFit.gamma * (1 - bestFit.alpha)
+			}
+			variance += c * c
+		}
+		width := z * sigma * math.Sqrt(variance)
+		result.Points[h-1] = Point{Value: value, Lower: value - width, Upper: value + width}
+	}
+	return result, nil
+}
+
+// hwFit  state of a fitted Holt-Winters model after the last observation
+type hwFit struct {
+	alpha, beta, gamma float64
+	level, trend       float64
+	seasonal           []float64 // one component per observation, seasonal[t] for period t
+	sse                float64
+}
+
+func fitHoltWinters(series []float64, m int, alpha, beta, gamma float64) *hwFit {
+	// Initial trend from the means of the first two seasons and level at the end
+	// of the first season. Seasonal components are the detrended deviations from
+	// each season's mean, averaged over both seasons.
+	var first, second float64
+	for i := 0; i < m; i++ {
+		first += series[i]
+		second += series[m+i]
+	}
+	first /= float64(m)
+	second /= float64(m)
+	trend := (second - first) / float64(m)
+	middle := float64(m-1) / 2
+
+	fit := &hwFit{
+		alpha:    alpha,
+		beta:     beta,
+		gamma:    gamma,
+		level:    first + trend*middle,
+		trend:    trend,
+		seasonal: make([]float64, len(series)),
+	}
+	for i := 0; i < m; i++ {
+		fit.seasonal[i] = (series[i]-first+series[m+i]-second)/2 - trend*(float64(i)-middle)
+	}
+
+	for t := m; t < len(series); t++ {
+		y := series[t]
+		season := fit.seasonal[t-m]
+		predicted := fit.level + fit.trend + season
+		e := y - predicted
+		fit.sse += e * e
+
+		level := alpha*(y-season) + (1-alpha)*(fit.level+fit.trend)
+		fit.trend = beta*(level-fit.level) + (1-beta)*fit.trend
+		fit.level = level
+		fit.seasonal[t] = gamma*(y-level) + (1-gamma)*season
+	}
+	return fit
+}
@@ -0,0 +1,192 @@
+package forecast
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// seasonalSeries is 10 + 0.5t plus a season of four periods summing to zero
+func seasonalSeries(n int) []float64 {
+	season := []float64{5, -3, 2, -4}
+	series := make([]float64, n)
+	for t := range series {
+		series[t] = 10 + 0.5*float64(t) + season[t%4]
+	}
+	return series
+}
+
+func TestForecast(t *testing.T) {
+	tests := []struct {
+		name       string
+		series     []float64
+		horizon    int
+		opts       Options
+		wantMethod Method
+		wantValues []float64
+		wantErr    error
+	}{
+		{
+			name:       "holt-winters recovers trend and season",
+			series:     seasonalSeries(16),
+			horizon:    6,
+			opts:       Options{Method: MethodHoltWinters, SeasonLength: 4, Level: 0.95},
+			wantMethod: MethodHoltWinters,
+			wantValues: seasonalSeries(22)[16:],
+		},
+		{
+			name:       "auto picks holt-winters with two seasons",
+			series:     seasonalSeries(8),
+			horizon:    4,
+			opts:       Options{Method: MethodAuto, SeasonLength: 4, Level: 0.95},
+			wantMethod: MethodHoltWinters,
+			wantValues: seasonalSeries(12)[8:],
+		},
+		{
+			name:       "linear trend",
+			series:     []float64{3, 5, 7, 9, 11, 13},
+			horizon:    3,
+			opts:       Options{Method: MethodLinear, Level: 0.95},
+			wantMethod: MethodLinear,
+			wantValues: []float64{15, 17, 19},
+		},
+		{
+			name:       "auto falls back to linear below two seasons",
+			series:     []float64{3, 5, 7, 9, 11, 13},
+			horizon:    2,
+			opts:       Options{Method: MethodAuto, SeasonLength: 4, Level: 0.95},
+			wantMethod: MethodLinear,
+			wantValues: []float64{15, 17},
+		},
+		{
+			name:       "non-negative clamps a falling trend",
+			series:     []float64{6, 4, 2},
+			horizon:    3,
+			opts:       Options{Method: MethodLinear, Level: 0.95, NonNegative: true},
+			wantMethod: MethodLinear,
+			wantValues: []float64{0, 0, 0},
+		},
+		{
+			name:    "holt-winters below two seasons",
+			series:  seasonalSeries(7),
+			horizon: 1,
+			opts:    Options{Method: MethodHoltWinters, SeasonLength: 4, Level: 0.95},
+			wantErr: ErrNotEnoughData,
+		},
+		{
+			name:    "linear with a single period",
+			series:  []float64{42},
+			horizon: 1,
+			opts:    Options{Method: MethodLinear, Level: 0.95},
+			wantErr: ErrNotEnoughData,
+		},
+		{
+			name:    "auto with a single period",
+			series:  []float64{42},
+			horizon: 1,
+			opts:    Options{Method: MethodAuto, SeasonLength: 12, Level: 0.95},
+			wantErr: ErrNotEnoughData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Forecast(tt.series, tt.horizon, tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Forecast() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Forecast() failed: %v", err)
+			}
+			if result.Method != tt.wantMethod {
+				t.Errorf("method = %s, want %s", result.Method, tt.wantMethod)
+			}
+			if len(result.Points) != len(tt.wantValues) {
+				t.Fatalf("got %d points, want %d", len(result.Points), len(tt.wantValues))
+			}
+			for i, p := range result.Points {
+				if math.Abs(p.Value-tt.wantValues[i]) > 1e-9 {
+					t.Errorf("point %d = %v, want %v", i, p.Value, tt.wantValues[i])
+				}
+				if p.Lower > p.Value || p.Upper < p.Value {
+					t.Errorf("point %d interval [%v, %v] does not contain %v", i, p.Lower, p.Upper, p.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestForecastIntervalWidth(t *testing.T) {
+	// Fitted line 1.3 + 0.8t leaves residuals ±0.3 and ±0.9, so sigma² = 1.8 / 2.
+	// One step ahead (t = 4) the width is z × sigma × sqrt(1 + 1/4 + 2.5²/5) = 1.5z.
+	series := []float64{1, 3, 2, 4}
+
+	tests := []struct {
+		level float64
+		z     float64
+	}{
+		{0.8, 1.2815515655446004},
+		{0.95, 1.959963984540054},
+		{0.99, 2.5758293035489004},
+	}
+	for _, tt := range tests {
+		result, err := Forecast(series, 3, Options{Method: MethodLinear, Level: tt.level})
+		if err != nil {
+			t.Fatalf("Forecast() failed: %v", err)
+		}
+
+		first := result.Points[0]
+		if math.Abs(first.Value-4.5) > 1e-9 {
+			t.Errorf("level %v: value = %v, want 4.5", tt.level, first.Value)
+		}
+		if width := first.Upper - first.Value; math.Abs(width-1.5*tt.z) > 1e-9 {
+			t.Errorf("level %v: width = %v, want %v", tt.level, width, 1.5*tt.z)
+		}
+		if below := first.Value - first.Lower; math.Abs(below-(first.Upper-first.Value)) > 1e-9 {
+			t.Errorf("level %v: interval [%v, %v] is not symmetric around %v", tt.level, first.Lower, first.Upper, first.Value)
+		}
+
+		// Uncertainty grows with the distance from the data
+		for i := 1; i < len(result.Points); i++ {
+			prev, cur := result.Points[i-1], result.Points[i]
+			if cur.Upper-cur.Lower <= prev.Upper-prev.Lower {
+				t.Errorf("level %v: interval of point %d is not wider than point %d", tt.level, i, i-1)
+			}
+		}
+	}
+}
+
+func TestForecastRejectsInvalidOptions(t *testing.T) {
+	series := []float64{1, 2, 3}
+	if _, err := Forecast(series, 0, Options{Method: MethodLinear, Level: 0.95}); err == nil {
+		t.Error("Forecast() with horizon 0 succeeded, want an error")
+	}
+	for _, level := range []float64{0, 1, -0.5, 1.5, math.NaN()} {
+		if _, err := Forecast(series, 1, Options{Method: MethodLinear, Level: level}); err == nil {
+			t.Errorf("Forecast() with level %v succeeded, want an error", level)
+		}
+	}
+}
+
+func TestHoltWintersIntervalWidens(t *testing.T) {
+	series := seasonalSeries(24)
+	for i := range series {
+		series[i] += []float64{0.4, -0.7, 0.2, 0.9, -0.3}[i%5]
+	}
+
+	result, err := Forecast(series, 8, Options{Method: MethodHoltWinters, SeasonLength: 4, Level: 0.95})
+	if err != nil {
+		t.Fatalf("Forecast() failed: %v", err)
+	}
+	if first := result.Points[0]; first.Upper-first.Lower <= 0 {
+		t.Fatalf("first interval [%v, %v] is empty on a noisy series", first.Lower, first.Upper)
+	}
+	for i := 1; i < len(result.Points); i++ {
+		prev, cur := result.Points[i-1], result.Points[i]
+		if cur.Upper-cur.Lower < prev.Upper-prev.Lower {
+			t.Errorf("interval of point %d is narrower than point %d", i, i-1)
+		}
+	}
+}
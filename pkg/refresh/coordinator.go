@@ -0,0 +1,508 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"sales_analytics/config"
+	"sales_analytics/pkg/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Conflict policies applied when a refresh is requested while another one is in progress
+const (
+	PolicyReject = "reject"
+	PolicyQueue  = "queue"
+)
+
+// leaseName identifies the Mongo lease that serialises refreshes across replicas
+const leaseName = "data_refresh"
+
+var (
+	// ErrRefreshInProgress is returned when a refresh is rejected because another one is running
+	ErrRefreshInProgress = errors.New("a data refresh is already in progress")
+
+	// ErrJobNotRunning is returned when cancelling a job that is neither queued nor running
+	ErrJobNotRunning = errors.New("refresh job is not running")
+
+	// ErrJobCommitted is returned when cancelling a refresh whose data is already loaded
+	ErrJobCommitted = errors.New("refresh has already committed its data and can no longer be cancelled")
+
+	errCancelRequested = errors.New("cancelled by request")
+)
+
+// Job  refresh job accepted by the coordinator
+type Job struct {
+	ID     primitive.ObjectID
+	Status string // queued or running
+}
+
+// TriggerRollup is the trigger of jobs that only rebuild the daily sales rollup
+const TriggerRollup = "rollup_rebuild"
+
+// job  refresh job tracked on this instance
+type job struct {
+	Job
+	trigger    string
+	rollupOnly bool // rebuild the daily rollup without loading the CSV
+	committed  bool // the CSV is loaded; the job only rebuilds the rollup and detects anomalies
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+}
+
+// covers reports whether running j also serves a request; every job rebuilds the
+// rollup, but only refreshes load the CSV
+func (j *job) covers(rollupOnly bool) bool {
+	return rollupOnly || !j.rollupOnly
+}
+
+// Coordinator is the single entry point for data refreshes. It lets at most one
+// refresh run at a time, both on this instance and, through a Mongo lease, across
+// all API replicas. Requests arriving while a refresh is in progress are rejected
+// or queued depending on the configured policy.
+type Coordinator struct {
+	repo       *repository.MongoRepository
+	config     *config.Config
+	instanceID string
+	mu         sync.Mutex
+	current    *job // running, or queued waiting for the lease
+	queued     *job // next job to run once current finishes
+	onSuccess  []func(jobID primitive.ObjectID)
+	workers    sync.WaitGroup // running jobs, waited for by Stop
+}
+
+// NewCoordinator creates a new refresh coordinator
+func NewCoordinator(repo *repository.MongoRepository, cfg *config.Config) *Coordinator {
+	hostname, _ := os.Hostname()
+	return &Coordinator{
+		repo:       repo,
+		config:     cfg,
+		instanceID: fmt.Sprintf("%s-%s", hostname, primitive.NewObjectID().Hex()),
+	}
+}
+
+// OnSuccess registers fn to be called on this instance after each job that
+// finishes with status success
+func (c *Coordinator) OnSuccess(fn func(jobID primitive.ObjectID)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSuccess = append(c.onSuccess, fn)
+}
+
+// succeeded publishes the new data version to every replica and runs the success
+// hooks of a job
+func (c *Coordinator) succeeded(j *job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.repo.BumpDataVersion(ctx, j.ID.Hex()); err != nil {
+		log.Printf("Refresh %s failed to publish the data version: %v", j.ID.Hex(), err)
+	}
+
+	c.mu.Lock()
+	hooks := slices.Clone(c.onSuccess)
+	c.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(j.ID)
+	}
+}
+
+// Start requests a data refresh. When no refresh is in progress the job starts
+// running in the background. Otherwise, under the queue policy the request is
+// queued (or coalesced with the job already queued), and under the reject policy
+// the job in progress is returned together with ErrRefreshInProgress.
+func (c *Coordinator) Start(ctx context.Context, trigger string) (Job, error) {
+	return c.start(ctx, trigger, false)
+}
+
+// RebuildRollup requests a rebuild of the daily sales rollup from scratch. It runs
+// as a job of its own, under the same lease and conflict policy as refreshes, so it
+// never overlaps a load.
+func (c *Coordinator) RebuildRollup(ctx context.Context) (Job, error) {
+	return c.start(ctx, TriggerRollup, true)
+}
+
+func (c *Coordinator) start(ctx context.Context, trigger string, rollupOnly bool) (Job, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil {
+		if c.config.RefreshConflictPolicy != PolicyQueue {
+			return c.current.Job, ErrRefreshInProgress
+		}
+		// Queued jobs load the same file, so later requests join a pending job
+		// that does at least as much
+		if c.current.Status == repository.RefreshQueued && c.current.covers(rollupOnly) {
+			return c.current.Job, nil
+		}
+		if c.queued != nil {
+			if c.queued.covers(rollupOnly) {
+				return c.queued.Job, nil
+			}
+			return c.queued.Job, ErrRefreshInProgress
+		}
+
+		j, err := c.newJob(ctx, trigger, repository.RefreshQueued)
+		if err != nil {
+			return Job{}, err
+		}
+		j.rollupOnly = rollupOnly
+		c.queued = j
+		return j.Job, nil
+	}
+
+	// Nothing runs locally, check whether another replica holds the lease
+	id := primitive.NewObjectID()
+	acquired, lease, err := c.repo.AcquireLease(ctx, leaseName, c.instanceID, id, c.config.RefreshLeaseTTL)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to acquire refresh lease: %w", err)
+	}
+
+	status := repository.RefreshRunning
+	if !acquired {
+		if c.config.RefreshConflictPolicy != PolicyQueue {
+			return Job{ID: lease.JobID, Status: repository.RefreshRunning}, ErrRefreshInProgress
+		}
+		status = repository.RefreshQueued
+	}
+
+	j, err := c.newJobWithID(ctx, id, trigger, status)
+	if err != nil {
+		if acquired {
+			c.releaseLease()
+		}
+		return Job{}, err
+	}
+	j.rollupOnly = rollupOnly
+	c.current = j
+	c.workers.Add(1)
+	go c.run(j)
+
+	return j.Job, nil
+}
+
+// newJob records a job with a fresh ID
+func (c *Coordinator) newJob(ctx context.Context, trigger, status string) (*job, error) {
+	return c.newJobWithID(ctx, primitive.NewObjectID(), trigger, status)
+}
+
+// newJobWithID records a job's refresh log and prepares its cancellable context
+func (c *Coordinator) newJobWithID(ctx context.Context, id primitive.ObjectID, trigger, status string) (*job, error) {
+	if err := c.repo.CreateRefreshLog(ctx, id, trigger, status); err != nil {
+		return nil, fmt.Errorf("failed to record refresh: %w", err)
+	}
+
+	jobCtx, cancel := context.WithCancelCause(context.Background())
+	return &job{
+		Job:     Job{ID: id, Status: status},
+		trigger: trigger,
+		ctx:     jobCtx,
+		cancel:  cancel,
+	}, nil
+}
+
+// run waits for the lease if needed, loads the CSV, rebuilds the daily rollup,
+// detects revenue anomalies, runs the success hooks and then starts the next queued job
+func (c *Coordinator) run(j *job) {
+	defer c.workers.Done()
+	defer c.finish(j)
+
+	ctx, cancel := context.WithTimeout(j.ctx, c.config.RefreshTimeout)
+	defer cancel()
+
+	if j.Status == repository.RefreshQueued {
+		if err := c.waitForLease(ctx, j); err != nil {
+			c.abandon(ctx, j, err)
+			return
+		}
+	}
+	defer c.releaseLease()
+
+	stopRenewal := c.renewLease(ctx, j)
+	defer stopRenewal()
+
+	log.Printf("Refresh %s started (trigger: %s)", j.ID.Hex(), j.trigger)
+
+	if j.rollupOnly {
+		err := c.rebuildRollup(ctx, j)
+		if c.finishRollupJob(ctx, j, err) == repository.RefreshSuccess {
+			c.succeeded(j)
+		}
+		return
+	}
+
+	// Orders change from here on, analytics read them directly until the rollup is rebuilt
+	if err := c.repo.InvalidateDailyRollup(ctx); err != nil {
+		log.Printf("Refresh %s failed to invalidate the daily rollup: %v", j.ID.Hex(), err)
+	}
+
+	loader := repository.NewDataLoader(c.repo, c.config)
+	if err := loader.LoadCSV(ctx, j.ID, c.config.CSVFilePath); err != nil {
+		log.Printf("Refresh %s failed: %v", j.ID.Hex(), err)
+		return
+	}
+	log.Printf("Refresh %s completed successfully", j.ID.Hex())
+
+	c.mu.Lock()
+	j.committed = true
+	c.mu.Unlock()
+
+	// The data is already loaded, so a failed rebuild only leaves analytics on orders
+	if err := c.rebuildRollup(ctx, j); err != nil {
+		log.Printf("Refresh %s failed to rebuild the daily rollup: %v", j.ID.Hex(), err)
+	}
+
+	// A failed detection only leaves the previous findings
+	found, err := c.repo.DetectAnomalies(ctx, j.ID, repository.AnomalyOptions{
+		Window:    c.config.AnomalyWindowDays,
+		Threshold: c.config.AnomalyThreshold,
+	})
+	if err != nil {
+		log.Printf("Refresh %s anomaly detection failed: %v", j.ID.Hex(), err)
+	} else {
+		log.Printf("Refresh %s found %d revenue anomalies", j.ID.Hex(), found)
+	}
+
+	c.succeeded(j)
+}
+
+// rebuildRollup recomputes the daily sales rollup from all orders
+func (c *Coordinator) rebuildRollup(ctx context.Context, j *job) error {
+	start := time.Now()
+	status, err := c.repo.RebuildDailyRollup(ctx, j.ID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Refresh %s rebuilt the daily rollup (%d rows) in %v", j.ID.Hex(), status.Rows, time.Since(start))
+	return nil
+}
+
+// finishRollupJob records the outcome of a job that only rebuilt the rollup and returns its status
+func (c *Coordinator) finishRollupJob(ctx context.Context, j *job, rebuildErr error) string {
+	status, errorMsg := repository.RefreshSuccess, ""
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		status, errorMsg = repository.RefreshCancelled, fmt.Sprintf("rollup rebuild cancelled: %v", context.Cause(ctx))
+	case rebuildErr != nil:
+		status, errorMsg = repository.RefreshFailed, fmt.Sprintf("rollup rebuild failed: %v", rebuildErr)
+	}
+
+	logCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := c.repo.FinishRefreshLog(logCtx, repository.RefreshLog{
+		ID:       j.ID,
+		EndTime:  time.Now(),
+		Status:   status,
+		ErrorMsg: errorMsg,
+		Progress: repository.RefreshProgress{UpdatedAt: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to record outcome of refresh %s: %v", j.ID.Hex(), err)
+	}
+	log.Printf("Refresh %s rollup rebuild %s", j.ID.Hex(), status)
+	return status
+}
+
+// waitForLease polls the lease until this instance holds it, then marks the job running
+func (c *Coordinator) waitForLease(ctx context.Context, j *job) error {
+	ticker := time.NewTicker(c.leaseCheckInterval())
+	defer ticker.Stop()
+
+	for {
+		acquired, _, err := c.repo.AcquireLease(ctx, leaseName, c.instanceID, j.ID, c.config.RefreshLeaseTTL)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Refresh %s failed to acquire lease: %v", j.ID.Hex(), err)
+		}
+		if acquired {
+			if err := c.repo.MarkRefreshRunning(ctx, j.ID); err != nil {
+				c.releaseLease()
+				return err
+			}
+			c.mu.Lock()
+			j.Status = repository.RefreshRunning
+			c.mu.Unlock()
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+			if c.cancelRequested(ctx, j) {
+				return context.Cause(j.ctx)
+			}
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// renewLease keeps the lease alive while the job runs. It cancels the job if the
+// lease is lost or another replica asked for the job to be cancelled.
+func (c *Coordinator) renewLease(ctx context.Context, j *job) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(c.leaseCheckInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := c.repo.RenewLease(ctx, leaseName, c.instanceID, c.config.RefreshLeaseTTL)
+				if errors.Is(err, repository.ErrLeaseLost) {
+					log.Printf("Refresh %s lost its lease, cancelling", j.ID.Hex())
+					j.cancel(err)
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Refresh %s failed to renew lease: %v", j.ID.Hex(), err)
+				}
+				c.cancelRequested(ctx, j)
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// cancelRequested cancels the job if its refresh log carries a cancellation request
+func (c *Coordinator) cancelRequested(ctx context.Context, j *job) bool {
+	refreshLog, err := c.repo.GetRefreshLog(ctx, j.ID)
+	if err != nil || !refreshLog.CancelRequested {
+		return false
+	}
+	j.cancel(errCancelRequested)
+	return true
+}
+
+// abandon records a job that ended before it started loading
+func (c *Coordinator) abandon(ctx context.Context, j *job, cause error) {
+	status := repository.RefreshCancelled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = repository.RefreshFailed
+	}
+
+	logCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := c.repo.FinishRefreshLog(logCtx, repository.RefreshLog{
+		ID:       j.ID,
+		EndTime:  time.Now(),
+		Status:   status,
+		ErrorMsg: fmt.Sprintf("refresh did not start: %v", cause),
+	})
+	if err != nil {
+		log.Printf("Failed to record outcome of refresh %s: %v", j.ID.Hex(), err)
+	}
+	log.Printf("Refresh %s %s before starting: %v", j.ID.Hex(), status, cause)
+}
+
+// finish clears the finished job and promotes the queued one
+func (c *Coordinator) finish(j *job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	j.cancel(nil)
+	if c.current == j {
+		c.current = nil
+	}
+
+	if c.queued != nil {
+		c.current, c.queued = c.queued, nil
+		c.workers.Add(1)
+		go c.run(c.current)
+	}
+}
+
+// releaseLease gives up the lease if this instance holds it
+func (c *Coordinator) releaseLease() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.repo.ReleaseLease(ctx, leaseName, c.instanceID); err != nil {
+		log.Printf("Failed to release refresh lease: %v", err)
+	}
+}
+
+// leaseCheckInterval renews the lease well before it expires
+func (c *Coordinator) leaseCheckInterval() time.Duration {
+	return max(c.config.RefreshLeaseTTL/3, time.Second)
+}
+
+// Cancel stops a queued or running job. Jobs owned by another replica are
+// flagged in their refresh log and cancelled by that replica. A refresh that has
+// loaded its data returns ErrJobCommitted, since the load is not rolled back.
+func (c *Coordinator) Cancel(ctx context.Context, jobID primitive.ObjectID) error {
+	c.mu.Lock()
+	if c.queued != nil && c.queued.ID == jobID {
+		j := c.queued
+		c.queued = nil
+		c.mu.Unlock()
+
+		j.cancel(errCancelRequested)
+		c.abandon(j.ctx, j, errCancelRequested)
+		return nil
+	}
+	if c.current != nil && c.current.ID == jobID {
+		if c.current.committed {
+			c.mu.Unlock()
+			return ErrJobCommitted
+		}
+		c.current.cancel(errCancelRequested)
+		c.mu.Unlock()
+		log.Printf("Refresh %s cancellation requested", jobID.Hex())
+		return nil
+	}
+	c.mu.Unlock()
+
+	requested, err := c.repo.RequestRefreshCancel(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if !requested {
+		return ErrJobNotRunning
+	}
+	log.Printf("Refresh %s cancellation requested from its owning instance", jobID.Hex())
+	return nil
+}
+
+// Stop cancels the running and queued jobs and waits up to timeout for them to
+// record their outcome and release the lease
+func (c *Coordinator) Stop(timeout time.Duration) {
+	c.mu.Lock()
+	if c.queued != nil {
+		c.queued.cancel(errors.New("server shutting down"))
+	}
+	if c.current != nil {
+		c.current.cancel(errors.New("server shutting down"))
+	}
+	c.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("Refresh still running %v after shutdown was requested, its lease is left to expire", timeout)
+	}
+}
@@ -0,0 +1,139 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"sales_analytics/config"
+	"sales_analytics/pkg/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestCoordinator connects to the MongoDB instance in MONGODB_TEST_URI using a
+// throwaway database and returns a coordinator with the given conflict policy
+func newTestCoordinator(tb testing.TB, policy string) (*Coordinator, *repository.MongoRepository) {
+	tb.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		tb.Skip("MONGODB_TEST_URI not set, skipping MongoDB test")
+	}
+
+	cfg := &config.Config{
+		MongoURI:              uri,
+		DatabaseName:          fmt.Sprintf("sales_analytics_test_%d", time.Now().UnixNano()),
+		RefreshTimeout:        time.Minute,
+		RefreshConflictPolicy: policy,
+		RefreshLeaseTTL:       time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	repo, err := repository.NewMongoRepository(ctx, cfg)
+	if err != nil {
+		tb.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+
+	coord := NewCoordinator(repo, cfg)
+	tb.Cleanup(func() {
+		coord.Stop(10 * time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = repo.GetCollection("leases").Database().Drop(ctx)
+		_ = repo.Disconnect(ctx)
+	})
+
+	return coord, repo
+}
+
+// waitForStatus polls a job's refresh log until it reaches status
+func waitForStatus(tb testing.TB, repo *repository.MongoRepository, id primitive.ObjectID, status string) {
+	tb.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		refreshLog, err := repo.GetRefreshLog(context.Background(), id)
+		if err == nil && refreshLog.Status == status {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	tb.Fatalf("refresh %s did not reach status %s", id.Hex(), status)
+}
+
+func TestRejectPolicyRefusesWhileAnotherReplicaHoldsTheLease(t *testing.T) {
+	coord, repo := newTestCoordinator(t, PolicyReject)
+	ctx := context.Background()
+
+	other := primitive.NewObjectID()
+	if acquired, _, err := repo.AcquireLease(ctx, leaseName, "other-replica", other, time.Minute); err != nil || !acquired {
+		t.Fatalf("AcquireLease() = %v, %v", acquired, err)
+	}
+
+	job, err := coord.RebuildRollup(ctx)
+	if !errors.Is(err, ErrRefreshInProgress) {
+		t.Fatalf("RebuildRollup() error = %v, want ErrRefreshInProgress", err)
+	}
+	if job.ID != other {
+		t.Errorf("reported job = %s, want the other replica's job %s", job.ID.Hex(), other.Hex())
+	}
+}
+
+func TestQueuePolicyWaitsForTheLease(t *testing.T) {
+	coord, repo := newTestCoordinator(t, PolicyQueue)
+	ctx := context.Background()
+
+	if acquired, _, err := repo.AcquireLease(ctx, leaseName, "other-replica", primitive.NewObjectID(), time.Minute); err != nil || !acquired {
+		t.Fatalf("AcquireLease() = %v, %v", acquired, err)
+	}
+
+	succeeded := make(chan primitive.ObjectID, 1)
+	coord.OnSuccess(func(id primitive.ObjectID) { succeeded <- id })
+
+	job, err := coord.RebuildRollup(ctx)
+	if err != nil || job.Status != repository.RefreshQueued {
+		t.Fatalf("RebuildRollup() = %+v, %v; want a queued job", job, err)
+	}
+
+	// A second request joins the queued job instead of adding another
+	again, err := coord.RebuildRollup(ctx)
+	if err != nil || again.ID != job.ID {
+		t.Fatalf("second RebuildRollup() = %+v, %v; want job %s", again, err, job.ID.Hex())
+	}
+
+	if err := repo.ReleaseLease(ctx, leaseName, "other-replica"); err != nil {
+		t.Fatalf("ReleaseLease() failed: %v", err)
+	}
+	waitForStatus(t, repo, job.ID, repository.RefreshSuccess)
+
+	select {
+	case id := <-succeeded:
+		if id != job.ID {
+			t.Errorf("success hook ran for %s, want %s", id.Hex(), job.ID.Hex())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("success hook did not run")
+	}
+}
+
+func TestQueuedJobTakesOverAnExpiredLease(t *testing.T) {
+	coord, repo := newTestCoordinator(t, PolicyQueue)
+	ctx := context.Background()
+
+	// The other replica stopped renewing; its lease runs out on its own
+	if acquired, _, err := repo.AcquireLease(ctx, leaseName, "crashed-replica", primitive.NewObjectID(), 500*time.Millisecond); err != nil || !acquired {
+		t.Fatalf("AcquireLease() = %v, %v", acquired, err)
+	}
+
+	job, err := coord.RebuildRollup(ctx)
+	if err != nil || job.Status != repository.RefreshQueued {
+		t.Fatalf("RebuildRollup() = %+v, %v; want a queued job", job, err)
+	}
+	waitForStatus(t, repo, job.ID, repository.RefreshSuccess)
+}
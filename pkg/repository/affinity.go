@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBasketItems bounds the distinct items of an analysed basket, since pairs grow
+// quadratically. Larger baskets are left out of the analysis entirely: pairing a
+// subset would favour the items that happen to be kept, and counting their items
+// without their pairs would understate lift.
+const maxBasketItems = 100
+
+// BasketKey  how order lines are grouped into baskets
+type BasketKey string
+
+// Supported basket keys
+const (
+	BasketCustomerDay BasketKey = "customer_day" // same customer on the same day
+	BasketByID        BasketKey = "basket_id"    // explicit basket_id column
+)
+
+// ParseBasketKey validates a basket key name
+func ParseBasketKey(name string) (BasketKey, error) {
+	switch k := BasketKey(name); k {
+	case BasketCustomerDay, BasketByID:
+		return k, nil
+	}
+	return "", fmt.Errorf("invalid basket_key %q, use customer_day or basket_id", name)
+}
+
+// AffinityLevel  item granularity of an affinity analysis
+type AffinityLevel string
+
+// Supported affinity levels
+const (
+	AffinityProduct  AffinityLevel = "product"
+	AffinityCategory AffinityLevel = "category"
+)
+
+// ParseAffinityLevel validates an affinity level name
+func ParseAffinityLevel(name string) (AffinityLevel, error) {
+	switch l := AffinityLevel(name); l {
+	case AffinityProduct, AffinityCategory:
+		return l, nil
+	}
+	return "", fmt.Errorf("invalid level %q, use product or category", name)
+}
+
+// Affinity sort orders
+const (
+	AffinitySortLift       = "lift"
+	AffinitySortSupport    = "support"
+	AffinitySortConfidence = "confidence"
+)
+
+// AffinityOptions  parameters of an affinity analysis
+type AffinityOptions struct {
+	BasketKey  BasketKey
+	Level      AffinityLevel
+	MinSupport float64 // minimum share of baskets containing the pair
+	MinCount   int     // minimum number of baskets containing the pair
+	SortBy     string  // lift, support or confidence
+	Limit      int
+}
+
+// ItemPair  association between two items bought in the same basket
+type ItemPair struct {
+	ItemA          string  `json:"item_a"`
+	NameA          string  `json:"name_a,omitempty"`
+	ItemB          string  `json:"item_b"`
+	NameB          string  `json:"name_b,omitempty"`
+	Baskets        int     `json:"baskets"`           // baskets containing both items
+	Support        float64 `json:"support"`           // P(A and B)
+	ConfidenceAToB float64 `json:"confidence_a_to_b"` // P(B | A)
+	ConfidenceBToA float64 `json:"confidence_b_to_a"` // P(A | B)
+	Lift           float64 `json:"lift"`              // P(A and B) / (P(A) × P(B))
+}
+
+// AffinityResult  top item associations
+type AffinityResult struct {
+	Baskets    int        `json:"baskets"`            // baskets analysed
+	MultiItem  int        `json:"multi_item_baskets"` // baskets with at least two distinct items
+	Oversized  int        `json:"oversized_baskets"`  // baskets skipped for holding more than maxBasketItems items
+	TotalPairs int        `json:"total_pairs"`        // pairs passing the thresholds
+	Pairs      []ItemPair `json:"pairs"`
+}
+
+// CalculateAffinity computes support, confidence and lift for pairs of products or
+// categories bought in the same basket within a date range. Only order lines matching
+// filter are placed in baskets, and baskets above maxBasketItems are counted but not analysed.
+func (r *MongoRepository) CalculateAffinity(ctx context.Context, startDate, endDate time.Time, opts AffinityOptions, filter bson.M) (*AffinityResult, error) {
+	match := withFilter(bson.M{
+		"date_of_sale": bson.M{
+			"$gte": startDate,
+			"$lte": endDate,
+		},
+	}, filter)
+
+	var basket interface{}
+	switch opts.BasketKey {
+	case BasketByID:
+		match["basket_id"] = bson.M{"$exists": true, "$ne": ""}
+		basket = "$basket_id"
+	default:
+		basket = bson.M{
+			"customer_id": "$customer_id",
+			"day":         bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$date_of_sale"}},
+		}
+	}
+
+	item := "$product_id"
+	if opts.Level == AffinityCategory {
+		item = "$category"
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   basket,
+			"items": bson.M{"$addToSet": item},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "items": 1}}},
+	}
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	type pairKey struct{ a, b string }
+	itemCounts := make(map[string]int)
+	pairCounts := make(map[pairKey]int)
+	result := &AffinityResult{}
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			Items []string `bson:"items"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		if len(doc.Items) > maxBasketItems {
+			result.Oversized++
+			continue
+		}
+
+		result.Baskets++
+		for _, it := range doc.Items {
+			itemCounts[it]++
+		}
+		if len(doc.Items) < 2 {
+			continue
+		}
+		result.MultiItem++
+
+		items := doc.Items
+		sort.Strings(items)
+		for i := 0; i < len(items); i++ {
+			for j := i + 1; j < len(items); j++ {
+				pairCounts[pairKey{items[i], items[j]}]++
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Pairs = []ItemPair{}
+	if result.Baskets == 0 {
+		return result, nil
+	}
+
+	n := float64(result.Baskets)
+	for key, count := range pairCounts {
+		support := float64(count) / n
+		if count < opts.MinCount || support < opts.MinSupport {
+			continue
+		}
+		countA, countB := float64(itemCounts[key.a]), float64(itemCounts[key.b])
+		result.Pairs = append(result.Pairs, ItemPair{
+			ItemA:          key.a,
+			ItemB:          key.b,
+			Baskets:        count,
+			Support:        support,
+			ConfidenceAToB: float64(count) / countA,
+			ConfidenceBToA: float64(count) / countB,
+			Lift:           support / ((countA / n) * (countB / n)),
+		})
+	}
+	result.TotalPairs = len(result.Pairs)
+
+	sortItemPairs(result.Pairs, opts.SortBy)
+	if opts.Limit > 0 && len(result.Pairs) > opts.Limit {
+		result.Pairs = result.Pairs[:opts.Limit]
+	}
+
+	if opts.Level == AffinityProduct {
+		if err := r.nameProductPairs(ctx, result.Pairs); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// sortItemPairs orders pairs by the requested measure, strongest first
+func sortItemPairs(pairs []ItemPair, sortBy string) {
+	measure := func(p ItemPair) float64 { return p.Lift }
+	switch sortBy {
+	case AffinitySortSupport:
+		measure = func(p ItemPair) float64 { return p.Support }
+	case AffinitySortConfidence:
+		measure = func(p ItemPair) float64 { return max(p.ConfidenceAToB, p.ConfidenceBToA) }
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		mi, mj := measure(pairs[i]), measure(pairs[j])
+		if mi != mj {
+			return mi > mj
+		}
+		if pairs[i].Baskets != pairs[j].Baskets {
+			return pairs[i].Baskets > pairs[j].Baskets
+		}
+		if pairs[i].ItemA != pairs[j].ItemA {
+			return pairs[i].ItemA < pairs[j].ItemA
+		}
+		return pairs[i].ItemB < pairs[j].ItemB
+	})
+}
+
+// nameProductPairs fills in the current product names of each pair
+func (r *MongoRepository) nameProductPairs(ctx context.Context, pairs []ItemPair) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(pairs)*2)
+	for _, p := range pairs {
+		ids = append(ids, p.ItemA, p.ItemB)
+	}
+
+	cursor, err := r.GetCollection("products").Find(ctx, bson.M{
+		"product_id": bson.M{"$in": ids},
+		"is_current": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+
+	names := make(map[string]string, len(products))
+	for _, p := range products {
+		names[p.ProductID] = p.Name
+	}
+	for i := range pairs {
+		pairs[i].NameA = names[pairs[i].ItemA]
+		pairs[i].NameB = names[pairs[i].ItemB]
+	}
+	return nil
+}
@@ -2,258 +2,170 @@ package repository
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// RevenueResult revenue calculation result
-type RevenueResult struct {
-	TotalRevenue float64 `bson:"total_revenue" json:"total_revenue"`
+// RevenueMetrics revenue breakdown of a set of orders
+type RevenueMetrics struct {
+	TotalRevenue      float64 `bson:"total_revenue" json:"total_revenue"` // same as net revenue
+	GrossRevenue      float64 `bson:"gross_revenue" json:"gross_revenue"` // quantity × unit price
+	TotalDiscount     float64 `bson:"total_discount" json:"total_discount"`
+	NetRevenue        float64 `bson:"net_revenue" json:"net_revenue"` // gross revenue − discount
+	ShippingRevenue   float64 `bson:"shipping_revenue" json:"shipping_revenue"`
+	Quantity          int     `bson:"quantity" json:"quantity"`
+	OrderCount        int     `bson:"order_count" json:"order_count"`
+	AverageOrderValue float64 `bson:"average_order_value" json:"average_order_value"` // net revenue / order count
+}
+
+// revenueMeasures are the pivot measures behind RevenueMetrics
+var revenueMeasures = []string{"revenue", "gross_revenue", "discount", "shipping", "quantity", "orders", "average_order_value"}
+
+// newRevenueMetrics reads RevenueMetrics from pivot values
+func newRevenueMetrics(values map[string]float64) RevenueMetrics {
+	return RevenueMetrics{
+		TotalRevenue:      values["revenue"],
+		GrossRevenue:      values["gross_revenue"],
+		TotalDiscount:     values["discount"],
+		NetRevenue:        values["revenue"],
+		ShippingRevenue:   values["shipping"],
+		Quantity:          int(values["quantity"]),
+		OrderCount:        int(values["orders"]),
+		AverageOrderValue: values["average_order_value"],
+	}
 }
 
 // ProductRevenueResult revenue by product
 type ProductRevenueResult struct {
-	ProductID    string  `bson:"_id" json:"product_id"`
-	ProductName  string  `bson:"product_name" json:"product_name"`
-	TotalRevenue float64 `bson:"total_revenue" json:"total_revenue"`
+	ProductID      string `bson:"_id" json:"product_id"`
+	ProductName    string `bson:"product_name" json:"product_name"`
+	RevenueMetrics `bson:",inline"`
+	Comparison     *RevenueComparison `bson:"-" json:"comparison,omitempty"`
 }
 
 // CategoryRevenueResult revenue by category
 type CategoryRevenueResult struct {
-	Category     string  `bson:"_id" json:"category"`
-	TotalRevenue float64 `bson:"total_revenue" json:"total_revenue"`
+	Category       string `bson:"_id" json:"category"`
+	RevenueMetrics `bson:",inline"`
+	Comparison     *RevenueComparison `bson:"-" json:"comparison,omitempty"`
 }
 
 // RegionRevenueResult revenue by region
 type RegionRevenueResult struct {
-	Region       string  `bson:"_id" json:"region"`
-	TotalRevenue float64 `bson:"total_revenue" json:"total_revenue"`
+	Region         string `bson:"_id" json:"region"`
+	RevenueMetrics `bson:",inline"`
+	Comparison     *RevenueComparison `bson:"-" json:"comparison,omitempty"`
 }
 
-// CalculateTotalRevenue total revenue for a date range
-func (r *MongoRepository) CalculateTotalRevenue(ctx context.Context, startDate, endDate time.Time) (float64, error) {
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{
-			"date_of_sale": bson.M{
-				"$gte": startDate,
-				"$lte": endDate,
-			},
-		}}},
-		// JOIN with products to get price and discount
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "products",
-			"localField":   "product_id",
-			"foreignField": "product_id",
-			"as":           "product",
-		}}},
-		{{Key: "$unwind", Value: "$product"}},
-		{{Key: "$group", Value: bson.M{
-			"_id": nil,
-			"total_revenue": bson.M{
-				"$sum": bson.M{
-					"$multiply": bson.A{
-						"$quantity_sold",
-						bson.M{"$subtract": bson.A{
-							"$product.unit_price",
-							bson.M{"$multiply": bson.A{
-								"$product.unit_price",
-								"$product.discount",
-							}},
-						}},
-					},
-				},
-			},
-		}}},
+// matchOrders builds the $match stage selecting orders sold in [startDate, endDate]
+// that also satisfy filter
+func matchOrders(startDate, endDate time.Time, filter bson.M) bson.D {
+	return bson.D{{Key: "$match", Value: withFilter(bson.M{
+		"date_of_sale": bson.M{
+			"$gte": startDate,
+			"$lte": endDate,
+		},
+	}, filter)}}
+}
+
+// withFilter adds the conditions of filter to match
+func withFilter(match, filter bson.M) bson.M {
+	for field, condition := range filter {
+		match[field] = condition
 	}
+	return match
+}
 
-	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+// CalculateTotalRevenue total revenue for a date range
+func (r *MongoRepository) CalculateTotalRevenue(ctx context.Context, startDate, endDate time.Time, filter bson.M) (float64, error) {
+	metrics, err := r.CalculateRevenueMetrics(ctx, startDate, endDate, filter)
 	if err != nil {
 		return 0, err
 	}
-	defer cursor.Close(ctx)
+	return metrics.NetRevenue, nil
+}
 
-	var results []RevenueResult
-	if err := cursor.All(ctx, &results); err != nil {
-		return 0, err
+// CalculateRevenueMetrics revenue breakdown for a date range
+func (r *MongoRepository) CalculateRevenueMetrics(ctx context.Context, startDate, endDate time.Time, filter bson.M) (RevenueMetrics, error) {
+	pivot, err := r.Pivot(ctx, PivotQuery{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Measures:  revenueMeasures,
+		Filter:    filter,
+	})
+	if err != nil {
+		return RevenueMetrics{}, err
 	}
 
-	if len(results) > 0 {
-		return results[0].TotalRevenue, nil
-	}
-	return 0, nil
+	return newRevenueMetrics(pivot.GrandTotal), nil
 }
 
 // CalculateRevenueByProduct revenue grouped by product
-func (r *MongoRepository) CalculateRevenueByProduct(ctx context.Context, startDate, endDate time.Time) ([]ProductRevenueResult, error) {
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{
-			"date_of_sale": bson.M{
-				"$gte": startDate,
-				"$lte": endDate,
-			},
-		}}},
-		// JOIN with products to get price, discount, and name
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "products",
-			"localField":   "product_id",
-			"foreignField": "product_id",
-			"as":           "product",
-		}}},
-		{{Key: "$unwind", Value: "$product"}},
-		{{Key: "$group", Value: bson.M{
-			"_id":          "$product_id",
-			"product_name": bson.M{"$first": "$product.name"},
-			"total_revenue": bson.M{
-				"$sum": bson.M{
-					"$multiply": bson.A{
-						"$quantity_sold",
-						bson.M{"$subtract": bson.A{
-							"$product.unit_price",
-							bson.M{"$multiply": bson.A{
-								"$product.unit_price",
-								"$product.discount",
-							}},
-						}},
-					},
-				},
-			},
-		}}},
-		{{Key: "$sort", Value: bson.M{"total_revenue": -1}}},
-	}
-
-	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+func (r *MongoRepository) CalculateRevenueByProduct(ctx context.Context, startDate, endDate time.Time, filter bson.M) ([]ProductRevenueResult, error) {
+	totals, labels, err := r.revenueBy(ctx, "product", startDate, endDate, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var results []ProductRevenueResult
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+	results := make([]ProductRevenueResult, len(totals))
+	for i, t := range totals {
+		results[i] = ProductRevenueResult{ProductID: t.Key[0], ProductName: labels[t.Key[0]], RevenueMetrics: newRevenueMetrics(t.Values)}
 	}
-
 	return results, nil
 }
 
 // CalculateRevenueByCategory revenue grouped by category
-func (r *MongoRepository) CalculateRevenueByCategory(ctx context.Context, startDate, endDate time.Time) ([]CategoryRevenueResult, error) {
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{
-			"date_of_sale": bson.M{
-				"$gte": startDate,
-				"$lte": endDate,
-			},
-		}}},
-		// JOIN with products to get category, price, and discount
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "products",
-			"localField":   "product_id",
-			"foreignField": "product_id",
-			"as":           "product",
-		}}},
-		{{Key: "$unwind", Value: "$product"}},
-		{{Key: "$group", Value: bson.M{
-			"_id": "$product.category",
-			"total_revenue": bson.M{
-				"$sum": bson.M{
-					"$multiply": bson.A{
-						"$quantity_sold",
-						bson.M{"$subtract": bson.A{
-							"$product.unit_price",
-							bson.M{"$multiply": bson.A{
-								"$product.unit_price",
-								"$product.discount",
-							}},
-						}},
-					},
-				},
-			},
-		}}},
-		{{Key: "$sort", Value: bson.M{"total_revenue": -1}}},
-	}
-
-	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+func (r *MongoRepository) CalculateRevenueByCategory(ctx context.Context, startDate, endDate time.Time, filter bson.M) ([]CategoryRevenueResult, error) {
+	totals, _, err := r.revenueBy(ctx, "category", startDate, endDate, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var results []CategoryRevenueResult
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+	results := make([]CategoryRevenueResult, len(totals))
+	for i, t := range totals {
+		results[i] = CategoryRevenueResult{Category: t.Key[0], RevenueMetrics: newRevenueMetrics(t.Values)}
 	}
-
 	return results, nil
 }
 
 // CalculateRevenueByRegion revenue grouped by region
-func (r *MongoRepository) CalculateRevenueByRegion(ctx context.Context, startDate, endDate time.Time) ([]RegionRevenueResult, error) {
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{
-			"date_of_sale": bson.M{
-				"$gte": startDate,
-				"$lte": endDate,
-			},
-		}}},
-		// JOIN with products to get price and discount
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "products",
-			"localField":   "product_id",
-			"foreignField": "product_id",
-			"as":           "product",
-		}}},
-		{{Key: "$unwind", Value: "$product"}},
-		{{Key: "$group", Value: bson.M{
-			"_id": "$region",
-			"total_revenue": bson.M{
-				"$sum": bson.M{
-					"$multiply": bson.A{
-						"$quantity_sold",
-						bson.M{"$subtract": bson.A{
-							"$product.unit_price",
-							bson.M{"$multiply": bson.A{
-								"$product.unit_price",
-								"$product.discount",
-							}},
-						}},
-					},
-				},
-			},
-		}}},
-		{{Key: "$sort", Value: bson.M{"total_revenue": -1}}},
-	}
-
-	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+func (r *MongoRepository) CalculateRevenueByRegion(ctx context.Context, startDate, endDate time.Time, filter bson.M) ([]RegionRevenueResult, error) {
+	totals, _, err := r.revenueBy(ctx, "region", startDate, endDate, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var results []RegionRevenueResult
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+	results := make([]RegionRevenueResult, len(totals))
+	for i, t := range totals {
+		results[i] = RegionRevenueResult{Region: t.Key[0], RevenueMetrics: newRevenueMetrics(t.Values)}
 	}
-
 	return results, nil
 }
 
-// GetRefreshLogs retrieves the latest refresh logs
-func (r *MongoRepository) GetRefreshLogs(ctx context.Context, limit int) ([]RefreshLog, error) {
-	opts := options.Find().SetSort(bson.M{"start_time": -1}).SetLimit(int64(limit))
-
-	cursor, err := r.GetCollection("refresh_logs").Find(ctx, bson.M{}, opts)
+// revenueBy pivots the revenue metrics on a single dimension and returns the row totals
+// sorted by revenue, highest first, then by key, together with the display names of
+// the dimension
+func (r *MongoRepository) revenueBy(ctx context.Context, dimension string, startDate, endDate time.Time, filter bson.M) ([]PivotTotal, map[string]string, error) {
+	pivot, err := r.Pivot(ctx, PivotQuery{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Rows:      []string{dimension},
+		Measures:  revenueMeasures,
+		Filter:    filter,
+		unbounded: true,
+	})
 	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var logs []RefreshLog
-	if err := cursor.All(ctx, &logs); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return logs, nil
+	totals := pivot.RowTotals
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Values["revenue"] != totals[j].Values["revenue"] {
+			return totals[i].Values["revenue"] > totals[j].Values["revenue"]
+		}
+		return totals[i].Key[0] < totals[j].Key[0]
+	})
+	return totals, pivot.Labels[dimension], nil
 }
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRevenueUnchangedAfterProductPriceChange(t *testing.T) {
+	repo, cfg := newTestRepository(t)
+	ctx := context.Background()
+
+	january := []time.Time{
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 31, 23, 59, 59, 0, time.UTC),
+	}
+
+	// 2 × (100 − 10%) = 180
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{
+		{"1001", "P1", "C1", "Widget", "Tools", "Europe", "2024-01-10", "2", "100.00", "0.1", "5.00", "PayPal", "Ann", "ann@example.com", "1 Main St"},
+	}))
+
+	before, err := repo.CalculateTotalRevenue(ctx, january[0], january[1], nil)
+	if err != nil {
+		t.Fatalf("CalculateTotalRevenue failed: %v", err)
+	}
+	if math.Abs(before-180) > 1e-9 {
+		t.Fatalf("January revenue = %v, want 180", before)
+	}
+
+	// The product is repriced in February
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{
+		{"1002", "P1", "C1", "Widget", "Tools", "Europe", "2024-02-10", "1", "150.00", "0.0", "5.00", "PayPal", "Ann", "ann@example.com", "1 Main St"},
+	}))
+
+	after, err := repo.CalculateTotalRevenue(ctx, january[0], january[1], nil)
+	if err != nil {
+		t.Fatalf("CalculateTotalRevenue failed: %v", err)
+	}
+	if after != before {
+		t.Errorf("January revenue changed after price change: got %v, want %v", after, before)
+	}
+
+	byProduct, err := repo.CalculateRevenueByProduct(ctx, january[0], january[1], nil)
+	if err != nil {
+		t.Fatalf("CalculateRevenueByProduct failed: %v", err)
+	}
+	if len(byProduct) != 1 || byProduct[0].TotalRevenue != before {
+		t.Errorf("January revenue by product = %+v, want a single product with %v", byProduct, before)
+	}
+
+	february, err := repo.CalculateTotalRevenue(ctx,
+		time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC),
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("CalculateTotalRevenue failed: %v", err)
+	}
+	if math.Abs(february-150) > 1e-9 {
+		t.Errorf("February revenue = %v, want 150", february)
+	}
+}
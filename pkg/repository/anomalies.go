@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Anomaly directions
+const (
+	AnomalySpike = "spike"
+	AnomalyDrop  = "drop"
+)
+
+// AnomalyDimensions are the order fields whose daily revenue is checked for anomalies
+var AnomalyDimensions = []string{"region", "category"}
+
+// Anomaly  day on which the revenue of a region or category left its expected band
+type Anomaly struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Dimension  string             `bson:"dimension" json:"dimension"` // region or category
+	Value      string             `bson:"value" json:"value"`
+	Date       time.Time          `bson:"date" json:"date"`
+	Revenue    float64            `bson:"revenue" json:"revenue"`
+	Median     float64            `bson:"median" json:"median"` // median of the preceding window
+	MAD        float64            `bson:"mad" json:"mad"`       // median absolute deviation of the window
+	Lower      float64            `bson:"lower" json:"lower"`
+	Upper      float64            `bson:"upper" json:"upper"`
+	Score      float64            `bson:"score" json:"score"`         // robust z-score
+	Severity   float64            `bson:"severity" json:"severity"`   // absolute score
+	Direction  string             `bson:"direction" json:"direction"` // spike or drop
+	RefreshID  primitive.ObjectID `bson:"refresh_id" json:"refresh_id"`
+	DetectedAt time.Time          `bson:"detected_at" json:"detected_at"`
+}
+
+// AnomalyOptions  parameters of anomaly detection
+type AnomalyOptions struct {
+	Window    int     // days of history the band is computed from
+	Threshold float64 // band half-width in scaled MADs
+}
+
+// AnomalyQuery  filter of stored anomalies. Zero values match everything.
+type AnomalyQuery struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Dimension string
+	Values    []string
+	Direction string
+	Limit     int
+	Offset    int
+}
+
+// madScale makes the MAD a consistent estimator of the standard deviation of normal data
+const madScale = 1.4826
+
+// DetectAnomalies computes daily revenue per region and per category over the whole
+// order history and flags the days whose revenue falls outside median ± k×MAD of the
+// preceding window. Days without sales count as zero revenue once a region or
+// category has started selling. The findings replace those of earlier runs; a
+// finding seen before keeps its original detection time. It returns the number of
+// anomalies found.
+func (r *MongoRepository) DetectAnomalies(ctx context.Context, refreshID primitive.ObjectID, opts AnomalyOptions) (int, error) {
+	if opts.Window < 2 || opts.Threshold <= 0 {
+		return 0, fmt.Errorf("invalid anomaly options: window %d, threshold %g", opts.Window, opts.Threshold)
+	}
+
+	var anomalies []Anomaly
+	for _, dimension := range AnomalyDimensions {
+		found, err := r.detectDimension(ctx, dimension, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to detect %s anomalies: %w", dimension, err)
+		}
+		anomalies = append(anomalies, found...)
+	}
+
+	coll := r.GetCollection("anomalies")
+	now := time.Now()
+	if len(anomalies) > 0 {
+		models := make([]mongo.WriteModel, len(anomalies))
+		for i, a := range anomalies {
+			models[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"dimension": a.Dimension, "value": a.Value, "date": a.Date}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"revenue":    a.Revenue,
+						"median":     a.Median,
+						"mad":        a.MAD,
+						"lower":      a.Lower,
+						"upper":      a.Upper,
+						"score":      a.Score,
+						"severity":   a.Severity,
+						"direction":  a.Direction,
+						"refresh_id": refreshID,
+					},
+					"$setOnInsert": bson.M{"detected_at": now},
+				}).
+				SetUpsert(true)
+		}
+		if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return 0, err
+		}
+	}
+
+	// Findings not confirmed by this run no longer hold for the current data
+	if _, err := coll.DeleteMany(ctx, bson.M{"refresh_id": bson.M{"$ne": refreshID}}); err != nil {
+		return 0, err
+	}
+
+	return len(anomalies), nil
+}
+
+// detectDimension checks the daily revenue of every value of one dimension over the
+// whole history. The scan is not limited to the days a refresh loaded: a new last
+// day of sales zero-fills every other value up to it, a changed window or threshold
+// moves every band, and migrations rewrite the revenue of old orders. Findings are
+// replaced as a whole for the same reason. The scan reads the daily rollup, which
+// each refresh has just rebuilt in full, so it costs less than the rebuild before it.
+func (r *MongoRepository) detectDimension(ctx context.Context, dimension string, opts AnomalyOptions) ([]Anomaly, error) {
+	source := r.salesSourceFor(ctx, time.Time{}, time.Time{}, nil, dimension)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"value": "$" + dimension,
+				"day":   GranularityDay.bucketExpr(),
+			},
+			"revenue": bson.M{"$sum": source.measure("revenue")},
+		}}},
+	}
+
+	cursor, err := r.GetCollection(source.collection).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID struct {
+			Value string    `bson:"value"`
+			Day   time.Time `bson:"day"`
+		} `bson:"_id"`
+		Revenue float64 `bson:"revenue"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	// Every series runs up to the last day with any sales, so a value that stopped
+	// selling shows up as a drop
+	var lastDay time.Time
+	days := make(map[string]map[time.Time]float64)
+	firstDay := make(map[string]time.Time)
+	for _, row := range rows {
+		day := row.ID.Day.UTC()
+		if days[row.ID.Value] == nil {
+			days[row.ID.Value] = make(map[time.Time]float64)
+			firstDay[row.ID.Value] = day
+		}
+		days[row.ID.Value][day] += row.Revenue
+		if day.Before(firstDay[row.ID.Value]) {
+			firstDay[row.ID.Value] = day
+		}
+		if day.After(lastDay) {
+			lastDay = day
+		}
+	}
+
+	var anomalies []Anomaly
+	for value, revenue := range days {
+		buckets := GranularityDay.Buckets(firstDay[value], lastDay)
+		series := make([]float64, len(buckets))
+		for i, b := range buckets {
+			series[i] = revenue[b]
+		}
+
+		for _, o := range robustOutliers(series, opts.Window, opts.Threshold) {
+			o.Anomaly.Dimension = dimension
+			o.Anomaly.Value = value
+			o.Anomaly.Date = buckets[o.index]
+			anomalies = append(anomalies, o.Anomaly)
+		}
+	}
+	return anomalies, nil
+}
+
+// outlier  anomaly found at an index of a series
+type outlier struct {
+	Anomaly
+	index int
+}
+
+// robustOutliers flags the points of series outside median ± k×MAD of the window
+// points preceding them. Points without a full window of history are not checked,
+// nor are points after a window without any spread, which gives no scale to judge by.
+func robustOutliers(series []float64, window int, k float64) []outlier {
+	var outliers []outlier
+	buf := make([]float64, window)
+	deviations := make([]float64, window)
+	for t := window; t < len(series); t++ {
+		copy(buf, series[t-window:t])
+		median := medianOf(buf)
+		for i, v := range series[t-window : t] {
+			deviations[i] = math.Abs(v - median)
+		}
+		mad := medianOf(deviations)
+
+		// A mostly constant window has no MAD, fall back to the mean absolute deviation
+		scale := madScale * mad
+		if scale == 0 {
+			var sum float64
+			for _, d := range deviations {
+				sum += d
+			}
+			scale = math.Sqrt(math.Pi/2) * sum / float64(window)
+		}
+		if scale == 0 {
+			continue
+		}
+
+		score := (series[t] - median) / scale
+		if math.Abs(score) <= k {
+			continue
+		}
+		direction := AnomalySpike
+		if score < 0 {
+			direction = AnomalyDrop
+		}
+		outliers = append(outliers, outlier{
+			Anomaly: Anomaly{
+				Revenue:   series[t],
+				Median:    median,
+				MAD:       mad,
+				Lower:     median - k*scale,
+				Upper:     median + k*scale,
+				Score:     score,
+				Severity:  math.Abs(score),
+				Direction: direction,
+			},
+			index: t,
+		})
+	}
+	return outliers
+}
+
+// medianOf sorts values in place and returns their median
+func medianOf(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// GetAnomalies retrieves stored anomalies, most recent first and most severe first within a day
+func (r *MongoRepository) GetAnomalies(ctx context.Context, q AnomalyQuery) ([]Anomaly, int64, error) {
+	coll := r.GetCollection("anomalies")
+
+	filter := bson.M{}
+	date := bson.M{}
+	if !q.StartDate.IsZero() {
+		date["$gte"] = q.StartDate
+	}
+	if !q.EndDate.IsZero() {
+		date["$lte"] = q.EndDate
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+	if q.Dimension != "" {
+		filter["dimension"] = q.Dimension
+	}
+	if len(q.Values) > 0 {
+		filter["value"] = bson.M{"$in": q.Values}
+	}
+	if q.Direction != "" {
+		filter["direction"] = q.Direction
+	}
+
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}, {Key: "severity", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(q.Offset)).
+		SetLimit(int64(q.Limit))
+
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	anomalies := []Anomaly{}
+	if err := cursor.All(ctx, &anomalies); err != nil {
+		return nil, 0, err
+	}
+	return anomalies, total, nil
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMedianOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"single value", []float64{7}, 7},
+		{"odd length", []float64{3, 1, 2}, 2},
+		{"even length", []float64{4, 1, 3, 2}, 2.5},
+		{"even length with ties", []float64{5, 5, 1, 9}, 5},
+		{"negative values", []float64{-1, -5, -3}, -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianOf(tt.values); got != tt.want {
+				t.Errorf("medianOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobustOutliers(t *testing.T) {
+	// wantOutlier is the part of an outlier the cases pin down
+	type wantOutlier struct {
+		index     int
+		direction string
+		median    float64
+		mad       float64
+		scale     float64 // band half-width is k × scale
+	}
+
+	tests := []struct {
+		name   string
+		series []float64
+		window int
+		k      float64
+		want   []wantOutlier
+	}{
+		{
+			name:   "spike after an even window",
+			series: []float64{8, 12, 10, 10, 30},
+			window: 4,
+			k:      3.5,
+			want:   []wantOutlier{{index: 4, direction: AnomalySpike, median: 10, mad: 1, scale: madScale}},
+		},
+		{
+			name:   "drop after an odd window",
+			series: []float64{8, 12, 10, 11, 9, 0},
+			window: 5,
+			k:      3.5,
+			want:   []wantOutlier{{index: 5, direction: AnomalyDrop, median: 10, mad: 1, scale: madScale}},
+		},
+		{
+			name:   "within the band",
+			series: []float64{8, 12, 10, 11, 9, 12},
+			window: 5,
+			k:      3.5,
+		},
+		{
+			name:   "points without a full window are not checked",
+			series: []float64{10, 11, 500},
+			window: 5,
+			k:      3.5,
+		},
+		{
+			// Four identical days leave no MAD, so the band comes from the mean
+			// absolute deviation (4 / 5) instead
+			name:   "zero MAD falls back to the mean absolute deviation",
+			series: []float64{10, 10, 10, 10, 14, 40},
+			window: 5,
+			k:      3.5,
+			want:   []wantOutlier{{index: 5, direction: AnomalySpike, median: 10, mad: 0, scale: math.Sqrt(math.Pi/2) * 0.8}},
+		},
+		{
+			// A window without any spread gives nothing to scale a deviation by, so
+			// the spike itself is not checked. Once it is in the window its days are.
+			name:   "constant series with one spike",
+			series: []float64{10, 10, 10, 10, 10, 50, 10, 10, -30},
+			window: 5,
+			k:      3.5,
+			want:   []wantOutlier{{index: 8, direction: AnomalyDrop, median: 10, mad: 0, scale: math.Sqrt(math.Pi/2) * 8}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := robustOutliers(tt.series, tt.window, tt.k)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d outliers, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				o := got[i]
+				if o.index != w.index || o.Direction != w.direction || o.Median != w.median || o.MAD != w.mad {
+					t.Errorf("outlier %d = index %d %s median %v mad %v, want index %d %s median %v mad %v",
+						i, o.index, o.Direction, o.Median, o.MAD, w.index, w.direction, w.median, w.mad)
+				}
+
+				wantScore := (tt.series[w.index] - w.median) / w.scale
+				if math.Abs(o.Score-wantScore) > 1e-9 || o.Severity != math.Abs(o.Score) {
+					t.Errorf("outlier %d score = %v severity %v, want %v", i, o.Score, o.Severity, wantScore)
+				}
+				if math.Abs(o.Lower-(w.median-tt.k*w.scale)) > 1e-9 || math.Abs(o.Upper-(w.median+tt.k*w.scale)) > 1e-9 {
+					t.Errorf("outlier %d band = [%v, %v], want median ± %v", i, o.Lower, o.Upper, tt.k*w.scale)
+				}
+				if o.Revenue != tt.series[w.index] {
+					t.Errorf("outlier %d revenue = %v, want %v", i, o.Revenue, tt.series[w.index])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAPIKeyNotFound is returned for unknown or revoked API keys
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// API key scopes
+const (
+	ScopeAnalyticsRead = "analytics:read" // revenue, customer and sales analytics
+	ScopeDataRefresh   = "data:refresh"   // data refreshes, refresh logs and the rollup
+	ScopeCronAdmin     = "cron:admin"     // the refresh cron job
+	ScopeKeysAdmin     = "keys:admin"     // API key management
+)
+
+// Scopes lists every API key scope
+var Scopes = []string{ScopeAnalyticsRead, ScopeDataRefresh, ScopeCronAdmin, ScopeKeysAdmin}
+
+// apiKeyPrefix starts every key, so leaked keys are easy to recognise
+const apiKeyPrefix = "sa_"
+
+// ParseScopes validates a list of scope names and removes duplicates
+func ParseScopes(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	scopes := make([]string, 0, len(names))
+	for _, name := range names {
+		if !slices.Contains(Scopes, name) {
+			return nil, fmt.Errorf("invalid scope %q, use analytics:read, data:refresh, cron:admin or keys:admin", name)
+		}
+		if !slices.Contains(scopes, name) {
+			scopes = append(scopes, name)
+		}
+	}
+	return scopes, nil
+}
+
+// APIKey  credential for the API. Only a SHA-256 hash of the key is stored; the key
+// itself is returned once, when it is created or rotated.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Hash       string             `bson:"hash" json:"-"`
+	Hint       string             `bson:"hint" json:"hint"` // first characters of the key
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	RotatedAt  *time.Time         `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	UseCount   int64              `bson:"use_count" json:"use_count"`
+}
+
+// HasScope reports whether the key grants scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// newAPIKey generates a random key and returns it with its hash and hint. Keys carry
+// 256 bits of entropy, so a plain SHA-256 hash is enough to protect them at rest.
+func newAPIKey() (key, hash, hint string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, hashAPIKey(key), key[:len(apiKeyPrefix)+6], nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey stores a new key with the given scopes and returns it with the key itself
+func (r *MongoRepository) CreateAPIKey(ctx context.Context, name string, scopes []string) (*APIKey, string, error) {
+	key, hash, hint, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey := &APIKey{
+		Name:      name,
+		Hash:      hash,
+		Hint:      hint,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	result, err := r.GetCollection("api_keys").InsertOne(ctx, apiKey)
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns every key, revoked ones included, oldest first
+func (r *MongoRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.GetCollection("api_keys").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces the key of an active API key, keeping its name and scopes.
+// The previous key stops working immediately.
+func (r *MongoRepository) RotateAPIKey(ctx context.Context, id primitive.ObjectID) (*APIKey, string, error) {
+	key, hash, hint, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var apiKey APIKey
+	err = r.GetCollection("api_keys").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"hash": hash, "hint": hint, "rotated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &apiKey, key, nil
+}
+
+// RevokeAPIKey disables an active API key for good. The key stays listed.
+func (r *MongoRepository) RevokeAPIKey(ctx context.Context, id primitive.ObjectID) (*APIKey, error) {
+	var apiKey APIKey
+	err := r.GetCollection("api_keys").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// AuthenticateAPIKey looks up an active API key and records its use. Use is buffered
+// and written by flushAPIKeyUsage, so authenticating a request does not write to MongoDB.
+func (r *MongoRepository) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := r.GetCollection("api_keys").FindOne(ctx,
+		bson.M{"hash": hashAPIKey(key), "revoked_at": bson.M{"$exists": false}},
+	).Decode(&apiKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.usage.record(apiKey.ID, time.Now())
+	return &apiKey, nil
+}
+
+// apiKeyUsageInterval is how often buffered API key use is written to MongoDB
+const apiKeyUsageInterval = 30 * time.Second
+
+// keyUse  use of one API key not yet written to MongoDB
+type keyUse struct {
+	count    int64
+	lastUsed time.Time
+}
+
+// apiKeyUsage  API key use buffered in memory between flushes
+type apiKeyUsage struct {
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]keyUse
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newAPIKeyUsage() *apiKeyUsage {
+	return &apiKeyUsage{
+		pending: make(map[primitive.ObjectID]keyUse),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// record adds one use of a key at the given time
+func (u *apiKeyUsage) record(id primitive.ObjectID, at time.Time) {
+	u.add(id, keyUse{count: 1, lastUsed: at})
+}
+
+// add merges use of a key into the buffer
+func (u *apiKeyUsage) add(id primitive.ObjectID, use keyUse) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.pending[id]
+	pending.count += use.count
+	if use.lastUsed.After(pending.lastUsed) {
+		pending.lastUsed = use.lastUsed
+	}
+	u.pending[id] = pending
+}
+
+// take returns the buffered use and starts a new buffer
+func (u *apiKeyUsage) take() map[primitive.ObjectID]keyUse {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.pending
+	u.pending = make(map[primitive.ObjectID]keyUse)
+	return pending
+}
+
+// flushAPIKeyUsage writes the buffered use of every key in one bulk write. Use that
+// fails to be written is kept for the next flush.
+func (r *MongoRepository) flushAPIKeyUsage(ctx context.Context) error {
+	pending := r.usage.take()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(pending))
+	for id, use := range pending {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{"use_count": use.count},
+				"$max": bson.M{"last_used_at": use.lastUsed},
+			}))
+	}
+	if _, err := r.GetCollection("api_keys").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		for id, use := range pending {
+			r.usage.add(id, use)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeAPIKeyUsage flushes buffered API key use every apiKeyUsageInterval until the
+// repository is disconnected
+func (r *MongoRepository) writeAPIKeyUsage() {
+	defer close(r.usage.done)
+
+	ticker := time.NewTicker(apiKeyUsageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.usage.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := r.flushAPIKeyUsage(ctx); err != nil {
+				log.Printf("Failed to record API key usage: %v", err)
+			}
+			cancel()
+		}
+	}
+}
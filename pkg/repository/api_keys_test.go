@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUsageIsBatched(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	apiKey, key, err := repo.CreateAPIKey(ctx, "reports", []string{ScopeAnalyticsRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	for range 3 {
+		if _, err := repo.AuthenticateAPIKey(ctx, key); err != nil {
+			t.Fatalf("AuthenticateAPIKey failed: %v", err)
+		}
+	}
+	if _, err := repo.AuthenticateAPIKey(ctx, "sa_unknown"); err != ErrAPIKeyNotFound {
+		t.Fatalf("AuthenticateAPIKey(unknown) = %v, want ErrAPIKeyNotFound", err)
+	}
+
+	usage := func() APIKey {
+		t.Helper()
+		keys, err := repo.ListAPIKeys(ctx)
+		if err != nil || len(keys) != 1 || keys[0].ID != apiKey.ID {
+			t.Fatalf("ListAPIKeys = %+v, %v, want the created key", keys, err)
+		}
+		return keys[0]
+	}
+
+	// Nothing is written until the buffer is flushed
+	if k := usage(); k.UseCount != 0 || k.LastUsedAt != nil {
+		t.Fatalf("before flush use_count = %d, last_used_at = %v, want 0 and nil", k.UseCount, k.LastUsedAt)
+	}
+
+	if err := repo.flushAPIKeyUsage(ctx); err != nil {
+		t.Fatalf("flushAPIKeyUsage failed: %v", err)
+	}
+	k := usage()
+	if k.UseCount != 3 || k.LastUsedAt == nil || time.Since(*k.LastUsedAt) > time.Minute {
+		t.Fatalf("after flush use_count = %d, last_used_at = %v, want 3 and just now", k.UseCount, k.LastUsedAt)
+	}
+
+	// Later flushes add to the stored count
+	if _, err := repo.AuthenticateAPIKey(ctx, key); err != nil {
+		t.Fatalf("AuthenticateAPIKey failed: %v", err)
+	}
+	if err := repo.flushAPIKeyUsage(ctx); err != nil {
+		t.Fatalf("flushAPIKeyUsage failed: %v", err)
+	}
+	if k := usage(); k.UseCount != 4 {
+		t.Errorf("after second flush use_count = %d, want 4", k.UseCount)
+	}
+}
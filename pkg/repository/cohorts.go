@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cohort  retention of the customers acquired in one month. Index i of Retention,
+// ActiveCustomers and Revenue is the i-th month after the cohort month.
+type Cohort struct {
+	Month           string    `json:"month"` // YYYY-MM of the first purchase
+	Customers       int       `json:"customers"`
+	ActiveCustomers []int     `json:"active_customers"`
+	Retention       []float64 `json:"retention"`
+	Revenue         []float64 `json:"revenue"`
+	TotalRevenue    float64   `json:"total_revenue"`
+}
+
+// CohortMatrix  cohort retention heatmap
+type CohortMatrix struct {
+	Periods int      `json:"periods"` // number of month offsets of the oldest cohort
+	Cohorts []Cohort `json:"cohorts"`
+}
+
+// maxCohortMonths bounds the number of cohorts; the matrix grows with its square
+const maxCohortMonths = 240
+
+// ErrTooManyCohorts is returned when a date range spans more than maxCohortMonths months
+var ErrTooManyCohorts = fmt.Errorf("cohort range spans more than %d months, use a shorter date range", maxCohortMonths)
+
+// CalculateCohortRetention groups customers by the month of their first purchase and
+// reports, for every following month up to endDate, the share of each cohort that
+// bought again and the cohort's revenue. Only cohorts whose first month lies within
+// [startDate, endDate] are returned; first purchases are determined over all history of
+// the orders matching filter. Returns ErrTooManyCohorts when the range spans more
+// than maxCohortMonths months.
+func (r *MongoRepository) CalculateCohortRetention(ctx context.Context, startDate, endDate time.Time, filter bson.M) (*CohortMatrix, error) {
+	firstMonth := GranularityMonth.BucketStart(startDate)
+	lastMonth := GranularityMonth.BucketStart(endDate)
+	if monthsBetween(firstMonth, lastMonth) >= maxCohortMonths {
+		return nil, ErrTooManyCohorts
+	}
+
+	match := withFilter(bson.M{"date_of_sale": bson.M{"$lte": endDate}}, filter)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"customer_id": "$customer_id",
+				"month":       GranularityMonth.bucketExpr(),
+			},
+			"revenue": bson.M{"$sum": "$line_revenue"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$_id.customer_id",
+			"cohort": bson.M{"$min": "$_id.month"},
+			"months": bson.M{"$push": bson.M{"month": "$_id.month", "revenue": "$revenue"}},
+		}}},
+		{{Key: "$match", Value: bson.M{
+			"cohort": bson.M{"$gte": firstMonth, "$lte": lastMonth},
+		}}},
+	}
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	months := GranularityMonth.Buckets(firstMonth, lastMonth)
+	cohorts := make([]Cohort, len(months))
+	for i, month := range months {
+		periods := len(months) - i
+		cohorts[i] = Cohort{
+			Month:           month.Format("2006-01"),
+			ActiveCustomers: make([]int, periods),
+			Retention:       make([]float64, periods),
+			Revenue:         make([]float64, periods),
+		}
+	}
+
+	for cursor.Next(ctx) {
+		var customer struct {
+			Cohort time.Time `bson:"cohort"`
+			Months []struct {
+				Month   time.Time `bson:"month"`
+				Revenue float64   `bson:"revenue"`
+			} `bson:"months"`
+		}
+		if err := cursor.Decode(&customer); err != nil {
+			return nil, err
+		}
+
+		cohort := &cohorts[monthsBetween(firstMonth, customer.Cohort)]
+		cohort.Customers++
+		for _, m := range customer.Months {
+			offset := monthsBetween(customer.Cohort, m.Month)
+			cohort.ActiveCustomers[offset]++
+			cohort.Revenue[offset] += m.Revenue
+			cohort.TotalRevenue += m.Revenue
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range cohorts {
+		cohort := &cohorts[i]
+		if cohort.Customers == 0 {
+			continue
+		}
+		for offset, active := range cohort.ActiveCustomers {
+			cohort.Retention[offset] = float64(active) / float64(cohort.Customers)
+		}
+	}
+
+	return &CohortMatrix{Periods: len(months), Cohorts: cohorts}, nil
+}
+
+// monthsBetween number of calendar months from the month of a to the month of b
+func monthsBetween(a, b time.Time) int {
+	a, b = a.UTC(), b.UTC()
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
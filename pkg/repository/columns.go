@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// CSV field keys understood by the column mapping
+const (
+	FieldOrderID         = "order_id"
+	FieldProductID       = "product_id"
+	FieldCustomerID      = "customer_id"
+	FieldProductName     = "product_name"
+	FieldCategory        = "category"
+	FieldRegion          = "region"
+	FieldDateOfSale      = "date_of_sale"
+	FieldQuantitySold    = "quantity_sold"
+	FieldUnitPrice       = "unit_price"
+	FieldDiscount        = "discount"
+	FieldShippingCost    = "shipping_cost"
+	FieldPaymentMethod   = "payment_method"
+	FieldCustomerName    = "customer_name"
+	FieldCustomerEmail   = "customer_email"
+	FieldCustomerAddress = "customer_address"
+	FieldBasketID        = "basket_id"
+)
+
+// requiredFields must be present in the CSV header for a refresh to start
+var requiredFields = []string{
+	FieldOrderID,
+	FieldProductID,
+	FieldCustomerID,
+	FieldProductName,
+	FieldCategory,
+	FieldRegion,
+	FieldDateOfSale,
+	FieldQuantitySold,
+	FieldUnitPrice,
+	FieldDiscount,
+}
+
+// fieldSetters assigns a raw cell value to the matching CSVRecord field
+var fieldSetters = map[string]func(*CSVRecord, string){
+	FieldOrderID:         func(r *CSVRecord, v string) { r.OrderID = v },
+	FieldProductID:       func(r *CSVRecord, v string) { r.ProductID = v },
+	FieldCustomerID:      func(r *CSVRecord, v string) { r.CustomerID = v },
+	FieldProductName:     func(r *CSVRecord, v string) { r.ProductName = v },
+	FieldCategory:        func(r *CSVRecord, v string) { r.Category = v },
+	FieldRegion:          func(r *CSVRecord, v string) { r.Region = v },
+	FieldDateOfSale:      func(r *CSVRecord, v string) { r.DateOfSale = v },
+	FieldQuantitySold:    func(r *CSVRecord, v string) { r.QuantitySold = v },
+	FieldUnitPrice:       func(r *CSVRecord, v string) { r.UnitPrice = v },
+	FieldDiscount:        func(r *CSVRecord, v string) { r.Discount = v },
+	FieldShippingCost:    func(r *CSVRecord, v string) { r.ShippingCost = v },
+	FieldPaymentMethod:   func(r *CSVRecord, v string) { r.PaymentMethod = v },
+	FieldCustomerName:    func(r *CSVRecord, v string) { r.CustomerName = v },
+	FieldCustomerEmail:   func(r *CSVRecord, v string) { r.CustomerEmail = v },
+	FieldCustomerAddress: func(r *CSVRecord, v string) { r.CustomerAddr = v },
+	FieldBasketID:        func(r *CSVRecord, v string) { r.BasketID = v },
+}
+
+// ColumnMapping maps a CSVRecord field key to the header names it may appear under
+type ColumnMapping map[string][]string
+
+// DefaultColumnMapping returns the header names recognised out of the box.
+// Header matching ignores case, spaces and punctuation, so "Order ID",
+// "Order Id" and "order_id" all resolve to the same field.
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		FieldOrderID:         {"Order ID", "Order Number", "Order No"},
+		FieldProductID:       {"Product ID", "SKU"},
+		FieldCustomerID:      {"Customer ID"},
+		FieldProductName:     {"Product Name", "Product"},
+		FieldCategory:        {"Category", "Product Category"},
+		FieldRegion:          {"Region", "Sales Region"},
+		FieldDateOfSale:      {"Date of Sale", "Sale Date", "Order Date"},
+		FieldQuantitySold:    {"Quantity Sold", "Quantity", "Qty"},
+		FieldUnitPrice:       {"Unit Price", "Price"},
+		FieldDiscount:        {"Discount"},
+		FieldShippingCost:    {"Shipping Cost", "Shipping"},
+		FieldPaymentMethod:   {"Payment Method"},
+		FieldCustomerName:    {"Customer Name"},
+		FieldCustomerEmail:   {"Customer Email", "Email"},
+		FieldCustomerAddress: {"Customer Address", "Address"},
+		FieldBasketID:        {"Basket ID", "Transaction ID", "Cart ID"},
+	}
+}
+
+// NewColumnMapping returns the default mapping extended with extra aliases.
+// Aliases for unknown field keys are logged and ignored.
+func NewColumnMapping(extra map[string][]string) ColumnMapping {
+	mapping := DefaultColumnMapping()
+	for field, aliases := range extra {
+		if _, ok := fieldSetters[field]; !ok {
+			log.Printf("Ignoring CSV column aliases for unknown field %q", field)
+			continue
+		}
+		mapping[field] = append(mapping[field], aliases...)
+	}
+	return mapping
+}
+
+// columnIndex maps field keys to column positions within a specific file
+type columnIndex map[string]int
+
+// resolve matches a CSV header against the mapping
+func (m ColumnMapping) resolve(header []string) (columnIndex, error) {
+	lookup := make(map[string]string)
+	for field, aliases := range m {
+		lookup[normalizeHeader(field)] = field
+		for _, alias := range aliases {
+			lookup[normalizeHeader(alias)] = field
+		}
+	}
+
+	cols := make(columnIndex)
+	for i, name := range header {
+		field, ok := lookup[normalizeHeader(name)]
+		if !ok {
+			log.Printf("Ignoring unmapped CSV column %q", name)
+			continue
+		}
+		if prev, dup := cols[field]; dup {
+			return nil, fmt.Errorf("columns %q and %q both map to %s", header[prev], name, field)
+		}
+		cols[field] = i
+	}
+
+	var missing []string
+	for _, field := range requiredFields {
+		if _, ok := cols[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required columns: %s", strings.Join(missing, ", "))
+	}
+
+	return cols, nil
+}
+
+// width returns the number of fields a row needs to cover every mapped column.
+// Optional columns count too, so a short row is rejected instead of silently
+// losing its shipping cost or customer details.
+func (c columnIndex) width() int {
+	width := 0
+	for _, idx := range c {
+		if idx+1 > width {
+			width = idx + 1
+		}
+	}
+	return width
+}
+
+// normalizeHeader lowercases a header name and drops everything but letters and digits
+func normalizeHeader(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
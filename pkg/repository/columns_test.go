@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestColumnMappingResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		aliases map[string][]string
+		want    map[string]int // expected positions of selected fields
+		wantErr string
+	}{
+		{
+			name:   "default header",
+			header: testCSVHeader,
+			want:   map[string]int{FieldOrderID: 0, FieldDiscount: 9, FieldCustomerAddress: 14},
+		},
+		{
+			name: "reordered header",
+			header: []string{
+				"Discount", "Unit Price", "Quantity Sold", "Date of Sale", "Region", "Category",
+				"Product Name", "Customer ID", "Product ID", "Order ID",
+			},
+			want: map[string]int{FieldDiscount: 0, FieldDateOfSale: 3, FieldOrderID: 9},
+		},
+		{
+			name: "case and punctuation are ignored",
+			header: []string{
+				"order_id", "PRODUCT-ID", "customer id", "Product Name", "category", "Region",
+				"date of sale", "Quantity_Sold", "unit.price", "DISCOUNT",
+			},
+			want: map[string]int{FieldOrderID: 0, FieldProductID: 1, FieldUnitPrice: 8},
+		},
+		{
+			name: "built-in aliases",
+			header: []string{
+				"Order No", "SKU", "Customer ID", "Product", "Product Category", "Sales Region",
+				"Order Date", "Qty", "Price", "Discount", "Email",
+			},
+			want: map[string]int{FieldOrderID: 0, FieldProductID: 1, FieldQuantitySold: 7, FieldCustomerEmail: 10},
+		},
+		{
+			name: "configured aliases",
+			header: []string{
+				"Bestellnummer", "Product ID", "Customer ID", "Product Name", "Category", "Region",
+				"Date of Sale", "Quantity Sold", "Unit Price", "Rabatt",
+			},
+			aliases: map[string][]string{FieldOrderID: {"Bestellnummer"}, FieldDiscount: {"Rabatt"}},
+			want:    map[string]int{FieldOrderID: 0, FieldDiscount: 9},
+		},
+		{
+			name: "unmapped columns are ignored",
+			header: []string{
+				"Notes", "Order ID", "Product ID", "Customer ID", "Product Name", "Category", "Region",
+				"Date of Sale", "Quantity Sold", "Unit Price", "Discount",
+			},
+			want: map[string]int{FieldOrderID: 1, FieldDiscount: 10},
+		},
+		{
+			name: "missing required columns",
+			header: []string{
+				"Order ID", "Product ID", "Customer ID", "Product Name", "Category",
+				"Date of Sale", "Quantity Sold", "Unit Price",
+			},
+			wantErr: "missing required columns: region, discount",
+		},
+		{
+			name: "two columns for one field",
+			header: []string{
+				"Order ID", "Order Number", "Product ID", "Customer ID", "Product Name", "Category",
+				"Region", "Date of Sale", "Quantity Sold", "Unit Price", "Discount",
+			},
+			wantErr: `columns "Order ID" and "Order Number" both map to order_id`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, err := NewColumnMapping(tt.aliases).resolve(tt.header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve() failed: %v", err)
+			}
+			for field, want := range tt.want {
+				if got, ok := cols[field]; !ok || got != want {
+					t.Errorf("column of %s = %d (found %v), want %d", field, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSVRowRejectsShortRows(t *testing.T) {
+	cols, err := DefaultColumnMapping().resolve(testCSVHeader)
+	if err != nil {
+		t.Fatalf("resolve() failed: %v", err)
+	}
+
+	full := []string{"1001", "P1", "C1", "Widget", "Tools", "Europe", "2024-01-10", "2", "100.00", "0.1", "5.00", "PayPal", "Ann", "ann@example.com", "1 Main St"}
+	dl := &DataLoader{}
+
+	record, err := dl.parseCSVRow(full, cols)
+	if err != nil {
+		t.Fatalf("parseCSVRow() rejected a full row: %v", err)
+	}
+	if record.CustomerAddr != "1 Main St" || record.ShippingCost != "5.00" {
+		t.Errorf("parseCSVRow() = %+v, want every column set", record)
+	}
+
+	// Missing only optional trailing columns still rejects the row
+	for _, width := range []int{len(full) - 1, 10, 3} {
+		if _, err := dl.parseCSVRow(full[:width], cols); err == nil || !strings.Contains(err.Error(), "expected at least 15") {
+			t.Errorf("parseCSVRow() with %d fields error = %v, want a short row error", width, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ComparisonMode  period a revenue figure is compared against
+type ComparisonMode string
+
+// Supported comparison modes
+const (
+	ComparePreviousPeriod ComparisonMode = "previous_period" // same number of days immediately before
+	ComparePreviousYear   ComparisonMode = "previous_year"   // same dates one year earlier
+)
+
+// ParseComparisonMode validates a comparison mode name
+func ParseComparisonMode(name string) (ComparisonMode, error) {
+	switch m := ComparisonMode(name); m {
+	case ComparePreviousPeriod, ComparePreviousYear:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid compare %q, use previous_period or previous_year", name)
+}
+
+// Range returns the comparison range for [startDate, endDate]
+func (m ComparisonMode) Range(startDate, endDate time.Time) (time.Time, time.Time) {
+	if m == ComparePreviousYear {
+		return yearEarlier(startDate), yearEarlier(endDate)
+	}
+	length := endDate.Sub(startDate)
+	previousEnd := startDate.Add(-time.Second)
+	return previousEnd.Add(-length), previousEnd
+}
+
+// yearEarlier moves t back one year. Feb 29 maps to Feb 28 rather than rolling over
+// into March, so a range ending in February stays in February.
+func yearEarlier(t time.Time) time.Time {
+	earlier := t.AddDate(-1, 0, 0)
+	if earlier.Day() != t.Day() {
+		earlier = earlier.AddDate(0, 0, -earlier.Day())
+	}
+	return earlier
+}
+
+// RevenueComparison  revenue of a group in the comparison period
+type RevenueComparison struct {
+	PreviousRevenue float64  `json:"previous_revenue"`
+	Change          float64  `json:"change"`
+	PercentChange   *float64 `json:"pct_change"` // nil when the previous revenue is zero
+}
+
+// NewRevenueComparison compares current revenue against previous revenue
+func NewRevenueComparison(current, previous float64) *RevenueComparison {
+	comparison := &RevenueComparison{
+		PreviousRevenue: previous,
+		Change:          current - previous,
+	}
+	if previous != 0 {
+		pct := (current - previous) / previous * 100
+		comparison.PercentChange = &pct
+	}
+	return comparison
+}
+
+// RevenueGroup is implemented by grouped revenue results that support comparison
+// and paging
+type RevenueGroup[T any] interface {
+	*T
+	groupKey() string
+	label() string // name the group sorts by
+	revenue() float64
+	metrics() RevenueMetrics
+	resetMetrics()
+	comparison() *RevenueComparison
+	setComparison(*RevenueComparison)
+}
+
+// CompareGroups attaches the previous period's revenue to every group. Groups that
+// only sold in the previous period are included with zero current revenue.
+func CompareGroups[T any, P RevenueGroup[T]](current, previous []T) []T {
+	previousRevenue := make(map[string]float64, len(previous))
+	for i := range previous {
+		p := P(&previous[i])
+		previousRevenue[p.groupKey()] = p.revenue()
+	}
+
+	seen := make(map[string]bool, len(current))
+	merged := make([]T, 0, len(current)+len(previous))
+	for _, group := range current {
+		p := P(&group)
+		p.setComparison(NewRevenueComparison(p.revenue(), previousRevenue[p.groupKey()]))
+		seen[p.groupKey()] = true
+		merged = append(merged, group)
+	}
+	for _, group := range previous {
+		p := P(&group)
+		if seen[p.groupKey()] {
+			continue
+		}
+		p.setComparison(NewRevenueComparison(0, p.revenue()))
+		p.resetMetrics()
+		merged = append(merged, group)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, b := P(&merged[i]), P(&merged[j])
+		if a.revenue() != b.revenue() {
+			return a.revenue() > b.revenue()
+		}
+		return previousRevenue[a.groupKey()] > previousRevenue[b.groupKey()]
+	})
+	return merged
+}
+
+func (r *ProductRevenueResult) groupKey() string                   { return r.ProductID }
+func (r *ProductRevenueResult) label() string                      { return r.ProductName }
+func (r *ProductRevenueResult) revenue() float64                   { return r.TotalRevenue }
+func (r *ProductRevenueResult) metrics() RevenueMetrics            { return r.RevenueMetrics }
+func (r *ProductRevenueResult) resetMetrics()                      { r.RevenueMetrics = RevenueMetrics{} }
+func (r *ProductRevenueResult) comparison() *RevenueComparison     { return r.Comparison }
+func (r *ProductRevenueResult) setComparison(c *RevenueComparison) { r.Comparison = c }
+
+func (r *CategoryRevenueResult) groupKey() string                   { return r.Category }
+func (r *CategoryRevenueResult) label() string                      { return r.Category }
+func (r *CategoryRevenueResult) revenue() float64                   { return r.TotalRevenue }
+func (r *CategoryRevenueResult) metrics() RevenueMetrics            { return r.RevenueMetrics }
+func (r *CategoryRevenueResult) resetMetrics()                      { r.RevenueMetrics = RevenueMetrics{} }
+func (r *CategoryRevenueResult) comparison() *RevenueComparison     { return r.Comparison }
+func (r *CategoryRevenueResult) setComparison(c *RevenueComparison) { r.Comparison = c }
+
+func (r *RegionRevenueResult) groupKey() string                   { return r.Region }
+func (r *RegionRevenueResult) label() string                      { return r.Region }
+func (r *RegionRevenueResult) revenue() float64                   { return r.TotalRevenue }
+func (r *RegionRevenueResult) metrics() RevenueMetrics            { return r.RevenueMetrics }
+func (r *RegionRevenueResult) resetMetrics()                      { r.RevenueMetrics = RevenueMetrics{} }
+func (r *RegionRevenueResult) comparison() *RevenueComparison     { return r.Comparison }
+func (r *RegionRevenueResult) setComparison(c *RevenueComparison) { r.Comparison = c }
+
+func (r *PaymentMethodRevenueResult) groupKey() string                   { return r.PaymentMethod }
+func (r *PaymentMethodRevenueResult) label() string                      { return r.PaymentMethod }
+func (r *PaymentMethodRevenueResult) revenue() float64                   { return r.TotalRevenue }
+func (r *PaymentMethodRevenueResult) metrics() RevenueMetrics            { return r.RevenueMetrics }
+func (r *PaymentMethodRevenueResult) resetMetrics()                      { r.RevenueMetrics, r.Share = RevenueMetrics{}, 0 }
+func (r *PaymentMethodRevenueResult) comparison() *RevenueComparison     { return r.Comparison }
+func (r *PaymentMethodRevenueResult) setComparison(c *RevenueComparison) { r.Comparison = c }
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComparisonModeRange(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute, second int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name               string
+		mode               ComparisonMode
+		start, end         time.Time
+		wantStart, wantEnd time.Time
+	}{
+		{"previous period", ComparePreviousPeriod,
+			at(2024, 3, 1, 0, 0, 0), at(2024, 3, 31, 23, 59, 59),
+			at(2024, 1, 30, 0, 0, 0), at(2024, 2, 29, 23, 59, 59)},
+		{"previous year", ComparePreviousYear,
+			at(2024, 3, 1, 0, 0, 0), at(2024, 3, 31, 23, 59, 59),
+			at(2023, 3, 1, 0, 0, 0), at(2023, 3, 31, 23, 59, 59)},
+		{"previous year of a leap February", ComparePreviousYear,
+			at(2024, 2, 1, 0, 0, 0), at(2024, 2, 29, 23, 59, 59),
+			at(2023, 2, 1, 0, 0, 0), at(2023, 2, 28, 23, 59, 59)},
+		{"previous year from Feb 29", ComparePreviousYear,
+			at(2024, 2, 29, 0, 0, 0), at(2024, 3, 6, 23, 59, 59),
+			at(2023, 2, 28, 0, 0, 0), at(2023, 3, 6, 23, 59, 59)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.mode.Range(tt.start, tt.end)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Range() = %v, %v, want %v, %v", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCompareGroupsIncludesPreviousOnlyGroups(t *testing.T) {
+	current := []RegionRevenueResult{
+		{Region: "Europe", RevenueMetrics: RevenueMetrics{TotalRevenue: 300, OrderCount: 3}},
+		{Region: "US", RevenueMetrics: RevenueMetrics{TotalRevenue: 100, OrderCount: 1}},
+	}
+	previous := []RegionRevenueResult{
+		{Region: "Asia", RevenueMetrics: RevenueMetrics{TotalRevenue: 500, OrderCount: 5}},
+		{Region: "Europe", RevenueMetrics: RevenueMetrics{TotalRevenue: 200, OrderCount: 2}},
+	}
+
+	merged := CompareGroups(current, previous)
+
+	type row struct {
+		region            string
+		revenue, previous float64
+		orders            int
+	}
+	var got []row
+	for _, g := range merged {
+		got = append(got, row{g.Region, g.TotalRevenue, g.Comparison.PreviousRevenue, g.OrderCount})
+	}
+	// Asia only sold in the previous period, so it sorts last with no current metrics
+	want := []row{
+		{"Europe", 300, 200, 3},
+		{"US", 100, 0, 1},
+		{"Asia", 0, 500, 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("CompareGroups() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("group %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if pct := merged[0].Comparison.PercentChange; pct == nil || *pct != 50 {
+		t.Errorf("Europe pct_change = %v, want 50", pct)
+	}
+	if pct := merged[1].Comparison.PercentChange; pct != nil {
+		t.Errorf("US pct_change = %v, want nil without previous revenue", *pct)
+	}
+	if c := merged[2].Comparison; c.Change != -500 || c.PercentChange == nil || *c.PercentChange != -100 {
+		t.Errorf("Asia comparison = %+v, want a change of -500 (-100%%)", c)
+	}
+}
@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrCustomerNotFound is returned when no customer matches the requested ID
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// millisPerDay converts date differences computed by MongoDB into days
+const millisPerDay = int64(24 * time.Hour / time.Millisecond)
+
+// CustomerRevenueResult revenue by customer
+type CustomerRevenueResult struct {
+	CustomerID   string  `bson:"_id" json:"customer_id"`
+	Name         string  `bson:"name" json:"name"`
+	Email        string  `bson:"email" json:"email"`
+	OrderCount   int     `bson:"order_count" json:"order_count"`
+	TotalRevenue float64 `bson:"total_revenue" json:"total_revenue"`
+}
+
+// CustomerLifetimeMetrics lifetime purchase metrics of a customer
+type CustomerLifetimeMetrics struct {
+	CustomerID               string    `bson:"_id" json:"customer_id"`
+	Name                     string    `bson:"name" json:"name"`
+	FirstPurchase            time.Time `bson:"first_purchase" json:"first_purchase"`
+	LastPurchase             time.Time `bson:"last_purchase" json:"last_purchase"`
+	OrderCount               int       `bson:"order_count" json:"order_count"`
+	TotalRevenue             float64   `bson:"total_revenue" json:"total_revenue"`
+	AverageOrderValue        float64   `bson:"average_order_value" json:"average_order_value"`
+	AverageDaysBetweenOrders *float64  `bson:"average_days_between_orders" json:"average_days_between_orders"` // nil with a single order
+}
+
+// CustomerSummary customer record with lifetime metrics and order history
+type CustomerSummary struct {
+	Customer Customer                `json:"customer"`
+	History  []Customer              `json:"history"` // every version of the customer's details, oldest first
+	Lifetime CustomerLifetimeMetrics `json:"lifetime"`
+	Orders   []Order                 `json:"orders"` // most recent first
+}
+
+// currentCustomerLookup joins the current version of each grouped customer and
+// copies its name and email onto the result
+func currentCustomerLookup() []bson.D {
+	return []bson.D{
+		{{Key: "$lookup", Value: bson.M{
+			"from": "customers",
+			"let":  bson.M{"customer_id": "$_id"},
+			"pipeline": mongo.Pipeline{
+				{{Key: "$match", Value: bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$customer_id", "$$customer_id"}},
+					bson.M{"$eq": bson.A{"$is_current", true}},
+				}}}}},
+				{{Key: "$limit", Value: 1}},
+			},
+			"as": "customer",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"name":  bson.M{"$first": "$customer.name"},
+			"email": bson.M{"$first": "$customer.email"},
+		}}},
+		{{Key: "$unset", Value: "customer"}},
+	}
+}
+
+// lifetimeStages groups orders per customer into lifetime metrics
+func lifetimeStages() []bson.D {
+	return []bson.D{
+		{{Key: "$group", Value: bson.M{
+			"_id":            "$customer_id",
+			"first_purchase": bson.M{"$min": "$date_of_sale"},
+			"last_purchase":  bson.M{"$max": "$date_of_sale"},
+			"order_count":    bson.M{"$sum": 1},
+			"total_revenue":  bson.M{"$sum": "$line_revenue"},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"average_order_value": bson.M{"$divide": bson.A{"$total_revenue", "$order_count"}},
+			"average_days_between_orders": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$order_count", 1}},
+				bson.M{"$divide": bson.A{
+					bson.M{"$subtract": bson.A{"$last_purchase", "$first_purchase"}},
+					bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{"$order_count", 1}}, millisPerDay}},
+				}},
+				nil,
+			}},
+		}}},
+	}
+}
+
+// CalculateTopCustomers the customers with the highest revenue in a date range
+func (r *MongoRepository) CalculateTopCustomers(ctx context.Context, startDate, endDate time.Time, limit int, filter bson.M) ([]CustomerRevenueResult, error) {
+	pipeline := mongo.Pipeline{
+		matchOrders(startDate, endDate, filter),
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$customer_id",
+			"order_count":   bson.M{"$sum": 1},
+			"total_revenue": bson.M{"$sum": "$line_revenue"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total_revenue", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	pipeline = append(pipeline, currentCustomerLookup()...)
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []CustomerRevenueResult{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CalculateCustomerLifetimeValues lifetime metrics per customer over the orders matching
+// filter, highest revenue first, together with the total number of customers
+func (r *MongoRepository) CalculateCustomerLifetimeValues(ctx context.Context, limit, offset int, filter bson.M) ([]CustomerLifetimeMetrics, int64, error) {
+	orders := r.GetCollection("orders")
+
+	total, err := r.countCustomersWithOrders(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: withFilter(bson.M{}, filter)}},
+	}
+	pipeline = append(pipeline, lifetimeStages()...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "total_revenue", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$skip", Value: offset}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	pipeline = append(pipeline, currentCustomerLookup()...)
+
+	cursor, err := orders.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []CustomerLifetimeMetrics{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// countCustomersWithOrders number of distinct customers with an order matching filter
+func (r *MongoRepository) countCustomersWithOrders(ctx context.Context, filter bson.M) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: withFilter(bson.M{}, filter)}},
+		{{Key: "$group", Value: bson.M{"_id": "$customer_id"}}},
+		{{Key: "$count", Value: "total"}},
+	}
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+
+	if len(results) > 0 {
+		return results[0].Total, nil
+	}
+	return 0, nil
+}
+
+// GetCustomerSummary returns the current customer record, its version history,
+// lifetime metrics and a page of its orders
+func (r *MongoRepository) GetCustomerSummary(ctx context.Context, customerID string, limit, offset int) (*CustomerSummary, error) {
+	summary := &CustomerSummary{}
+
+	// Customer versions, oldest first
+	opts := options.Find().SetSort(bson.M{"valid_from": 1})
+	cursor, err := r.GetCollection("customers").Find(ctx, bson.M{"customer_id": customerID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &summary.History); err != nil {
+		return nil, err
+	}
+	if len(summary.History) == 0 {
+		return nil, ErrCustomerNotFound
+	}
+	summary.Customer = summary.History[len(summary.History)-1]
+
+	// Lifetime metrics
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"customer_id": customerID}}},
+	}
+	pipeline = append(pipeline, lifetimeStages()...)
+
+	lifetimeCursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer lifetimeCursor.Close(ctx)
+
+	var lifetime []CustomerLifetimeMetrics
+	if err := lifetimeCursor.All(ctx, &lifetime); err != nil {
+		return nil, err
+	}
+	if len(lifetime) > 0 {
+		summary.Lifetime = lifetime[0]
+	}
+	summary.Lifetime.CustomerID = customerID
+	summary.Lifetime.Name = summary.Customer.Name
+
+	// Order history, most recent first
+	orderOpts := options.Find().
+		SetSort(bson.D{{Key: "date_of_sale", Value: -1}, {Key: "order_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	orderCursor, err := r.GetCollection("orders").Find(ctx, bson.M{"customer_id": customerID}, orderOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer orderCursor.Close(ctx)
+
+	summary.Orders = []Order{}
+	if err := orderCursor.All(ctx, &summary.Orders); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dataVersionID identifies the single document of the data_version collection
+const dataVersionID = "sales"
+
+// DataVersion  marker replaced whenever the sales data changes. Every replica reads
+// it, so results cached anywhere can be told apart from the current data.
+type DataVersion struct {
+	Version   primitive.ObjectID `bson:"version" json:"version"`
+	ChangedBy string             `bson:"changed_by" json:"changed_by"` // refresh ID or tool that changed the data
+	ChangedAt time.Time          `bson:"changed_at" json:"changed_at"`
+}
+
+// BumpDataVersion records that changedBy has finished changing the sales data
+func (r *MongoRepository) BumpDataVersion(ctx context.Context, changedBy string) error {
+	_, err := r.GetCollection("data_version").UpdateOne(ctx,
+		bson.M{"_id": dataVersionID},
+		bson.M{"$set": DataVersion{
+			Version:   primitive.NewObjectID(),
+			ChangedBy: changedBy,
+			ChangedAt: time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetDataVersion returns the current data version, empty before the data ever changed
+func (r *MongoRepository) GetDataVersion(ctx context.Context) (string, error) {
+	var version DataVersion
+	err := r.GetCollection("data_version").FindOne(ctx, bson.M{"_id": dataVersionID}).Decode(&version)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return version.Version.Hex(), nil
+}
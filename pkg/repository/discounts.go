@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultDiscountBandEdges are the upper bounds of the discounted bands used when none are given
+var DefaultDiscountBandEdges = []float64{0.1, 0.2, 0.3}
+
+// DiscountBand  range of discounts. Orders without discount form their own band;
+// other bands include their upper bound, e.g. (0.1, 0.2].
+type DiscountBand struct {
+	Label       string  `json:"label"`
+	MinDiscount float64 `json:"min_discount"`
+	MaxDiscount float64 `json:"max_discount"`
+}
+
+// NewDiscountBands builds the no-discount band followed by one band per edge and
+// a final band up to 100%. Edges must be strictly increasing and between 0 and 1.
+func NewDiscountBands(edges []float64) ([]DiscountBand, error) {
+	bands := []DiscountBand{{Label: "0%"}}
+	lower := 0.0
+	for _, edge := range edges {
+		// Written so that NaN, which fails every comparison, is rejected too
+		if !(edge > lower && edge < 1) {
+			return nil, errors.New("discount band edges must be increasing and between 0 and 1")
+		}
+		bands = append(bands, DiscountBand{Label: bandLabel(lower, edge), MinDiscount: lower, MaxDiscount: edge})
+		lower = edge
+	}
+	bands = append(bands, DiscountBand{Label: bandLabel(lower, 1), MinDiscount: lower, MaxDiscount: 1})
+	return bands, nil
+}
+
+func bandLabel(lower, upper float64) string {
+	if upper == 1 && lower > 0 {
+		return fmt.Sprintf("%g%%+", lower*100)
+	}
+	return fmt.Sprintf("%g-%g%%", lower*100, upper*100)
+}
+
+// DiscountBandMetrics  sales of one discount band
+type DiscountBandMetrics struct {
+	Band                    string  `json:"band"`
+	Quantity                int     `json:"quantity"`
+	OrderCount              int     `json:"order_count"`
+	GrossRevenue            float64 `json:"gross_revenue"`
+	NetRevenue              float64 `json:"net_revenue"`
+	RevenueLost             float64 `json:"revenue_lost"` // discount given away
+	AverageQuantityPerOrder float64 `json:"average_quantity_per_order"`
+}
+
+func (m *DiscountBandMetrics) add(o DiscountBandMetrics) {
+	m.Quantity += o.Quantity
+	m.OrderCount += o.OrderCount
+	m.GrossRevenue += o.GrossRevenue
+	m.NetRevenue += o.NetRevenue
+	m.RevenueLost += o.RevenueLost
+}
+
+// CategoryDiscountBands  discount bands of one category
+type CategoryDiscountBands struct {
+	Category string                `json:"category"`
+	Bands    []DiscountBandMetrics `json:"bands"`
+}
+
+// ProductDiscountBands  discount bands of one product
+type ProductDiscountBands struct {
+	ProductID   string                `json:"product_id"`
+	ProductName string                `json:"product_name"`
+	Category    string                `json:"category"`
+	Bands       []DiscountBandMetrics `json:"bands"`
+}
+
+// DiscountAnalysis  sales bucketed by discount band overall, per category and per product
+type DiscountAnalysis struct {
+	Bands         []DiscountBand          `json:"bands"`
+	Totals        []DiscountBandMetrics   `json:"totals"`
+	ByCategory    []CategoryDiscountBands `json:"by_category"`
+	ByProduct     []ProductDiscountBands  `json:"by_product"`
+	TotalProducts int                     `json:"total_products"`
+}
+
+// CalculateDiscountEffectiveness buckets the orders in a date range by the discount
+// given at sale time and compares units sold, revenue and revenue lost per band.
+// Products are ordered by net revenue and limited to productLimit.
+func (r *MongoRepository) CalculateDiscountEffectiveness(ctx context.Context, startDate, endDate time.Time, bands []DiscountBand, productLimit int, filter bson.M) (*DiscountAnalysis, error) {
+	pipeline := mongo.Pipeline{
+		matchOrders(startDate, endDate, filter),
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"band":       discountBandExpr(bands),
+				"category":   "$category",
+				"product_id": "$product_id",
+			},
+			"product_name":  bson.M{"$first": "$product_name"},
+			"quantity":      bson.M{"$sum": "$quantity_sold"},
+			"order_count":   bson.M{"$sum": 1},
+			"gross_revenue": bson.M{"$sum": grossExpr},
+			"net_revenue":   bson.M{"$sum": "$line_revenue"},
+		}}},
+	}
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID struct {
+			Band      int    `bson:"band"`
+			Category  string `bson:"category"`
+			ProductID string `bson:"product_id"`
+		} `bson:"_id"`
+		ProductName  string  `bson:"product_name"`
+		Quantity     int     `bson:"quantity"`
+		OrderCount   int     `bson:"order_count"`
+		GrossRevenue float64 `bson:"gross_revenue"`
+		NetRevenue   float64 `bson:"net_revenue"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	newBands := func() []DiscountBandMetrics {
+		metrics := make([]DiscountBandMetrics, len(bands))
+		for i, b := range bands {
+			metrics[i].Band = b.Label
+		}
+		return metrics
+	}
+
+	analysis := &DiscountAnalysis{Bands: bands, Totals: newBands()}
+	categories := make(map[string]*CategoryDiscountBands)
+	products := make(map[string]*ProductDiscountBands)
+	productRevenue := make(map[string]float64)
+	for _, row := range rows {
+		m := DiscountBandMetrics{
+			Quantity:     row.Quantity,
+			OrderCount:   row.OrderCount,
+			GrossRevenue: row.GrossRevenue,
+			NetRevenue:   row.NetRevenue,
+			RevenueLost:  row.GrossRevenue - row.NetRevenue,
+		}
+
+		category, ok := categories[row.ID.Category]
+		if !ok {
+			category = &CategoryDiscountBands{Category: row.ID.Category, Bands: newBands()}
+			categories[row.ID.Category] = category
+		}
+		product, ok := products[row.ID.ProductID]
+		if !ok {
+			product = &ProductDiscountBands{ProductID: row.ID.ProductID, ProductName: row.ProductName, Category: row.ID.Category, Bands: newBands()}
+			products[row.ID.ProductID] = product
+		}
+
+		analysis.Totals[row.ID.Band].add(m)
+		category.Bands[row.ID.Band].add(m)
+		product.Bands[row.ID.Band].add(m)
+		productRevenue[row.ID.ProductID] += row.NetRevenue
+	}
+
+	for _, category := range categories {
+		analysis.ByCategory = append(analysis.ByCategory, *category)
+	}
+	sort.Slice(analysis.ByCategory, func(i, j int) bool {
+		return analysis.ByCategory[i].Category < analysis.ByCategory[j].Category
+	})
+
+	for _, product := range products {
+		analysis.ByProduct = append(analysis.ByProduct, *product)
+	}
+	sort.Slice(analysis.ByProduct, func(i, j int) bool {
+		a, b := analysis.ByProduct[i], analysis.ByProduct[j]
+		if productRevenue[a.ProductID] != productRevenue[b.ProductID] {
+			return productRevenue[a.ProductID] > productRevenue[b.ProductID]
+		}
+		return a.ProductID < b.ProductID
+	})
+	analysis.TotalProducts = len(analysis.ByProduct)
+	if len(analysis.ByProduct) > productLimit {
+		analysis.ByProduct = analysis.ByProduct[:productLimit]
+	}
+	if analysis.ByCategory == nil {
+		analysis.ByCategory = []CategoryDiscountBands{}
+	}
+	if analysis.ByProduct == nil {
+		analysis.ByProduct = []ProductDiscountBands{}
+	}
+
+	finishBands(analysis.Totals)
+	for i := range analysis.ByCategory {
+		finishBands(analysis.ByCategory[i].Bands)
+	}
+	for i := range analysis.ByProduct {
+		finishBands(analysis.ByProduct[i].Bands)
+	}
+
+	return analysis, nil
+}
+
+// finishBands computes the averages of summed band metrics
+func finishBands(metrics []DiscountBandMetrics) {
+	for i := range metrics {
+		if metrics[i].OrderCount > 0 {
+			metrics[i].AverageQuantityPerOrder = float64(metrics[i].Quantity) / float64(metrics[i].OrderCount)
+		}
+	}
+}
+
+// discountBandExpr maps an order's discount to the index of its band
+func discountBandExpr(bands []DiscountBand) bson.M {
+	branches := bson.A{
+		bson.M{"case": bson.M{"$lte": bson.A{"$discount", 0}}, "then": 0},
+	}
+	for i := 1; i < len(bands)-1; i++ {
+		branches = append(branches, bson.M{
+			"case": bson.M{"$lte": bson.A{"$discount", bands[i].MaxDiscount}},
+			"then": i,
+		})
+	}
+	return bson.M{"$switch": bson.M{
+		"branches": branches,
+		"default":  len(bands) - 1,
+	}}
+}
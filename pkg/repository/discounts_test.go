@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNewDiscountBands(t *testing.T) {
+	bands, err := NewDiscountBands([]float64{0.1, 0.25})
+	if err != nil {
+		t.Fatalf("NewDiscountBands failed: %v", err)
+	}
+	want := []DiscountBand{
+		{Label: "0%"},
+		{Label: "0-10%", MinDiscount: 0, MaxDiscount: 0.1},
+		{Label: "10-25%", MinDiscount: 0.1, MaxDiscount: 0.25},
+		{Label: "25%+", MinDiscount: 0.25, MaxDiscount: 1},
+	}
+	if !reflect.DeepEqual(bands, want) {
+		t.Errorf("bands = %+v, want %+v", bands, want)
+	}
+
+	for _, edges := range [][]float64{
+		{0},
+		{1},
+		{0.3, 0.2},
+		{0.2, 0.2},
+		{math.NaN()},
+		{0.1, math.NaN()},
+		{math.Inf(1)},
+	} {
+		if _, err := NewDiscountBands(edges); err == nil {
+			t.Errorf("NewDiscountBands(%v) succeeded, want an error", edges)
+		}
+	}
+}
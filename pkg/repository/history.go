@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyBatchSize bounds the number of version updates sent in one BulkWrite and
+// the number of keys compacted per query
+const historyBatchSize = 1000
+
+// versionedEntity describes a collection that keeps type-2 history per business key
+type versionedEntity struct {
+	collection string
+	key        string
+	attributes []string
+}
+
+var (
+	productHistory = versionedEntity{
+		collection: "products",
+		key:        "product_id",
+		attributes: []string{"name", "category", "unit_price", "discount"},
+	}
+	customerHistory = versionedEntity{
+		collection: "customers",
+		key:        "customer_id",
+		attributes: []string{"name", "email", "address"},
+	}
+)
+
+// productVersion builds an upsert recording a product as observed on its valid_from date
+func productVersion(p Product) mongo.WriteModel {
+	return observeVersion(productHistory.key, p.ProductID, p.ValidFrom, bson.M{
+		"name":       p.Name,
+		"category":   p.Category,
+		"unit_price": p.UnitPrice,
+		"discount":   p.Discount,
+	})
+}
+
+// customerVersion builds an upsert recording a customer as observed on its valid_from date
+func customerVersion(cu Customer) mongo.WriteModel {
+	return observeVersion(customerHistory.key, cu.CustomerID, cu.ValidFrom, bson.M{
+		"name":    cu.Name,
+		"email":   cu.Email,
+		"address": cu.Address,
+	})
+}
+
+// observeVersion upserts the attributes observed for key on date. Validity ranges
+// are settled afterwards by CompactHistory.
+func observeVersion(keyField, key string, date time.Time, attributes bson.M) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{keyField: key, "valid_from": date}).
+		SetUpdate(bson.M{
+			"$set":         attributes,
+			"$setOnInsert": bson.M{"valid_to": nil, "is_current": false},
+		}).
+		SetUpsert(true)
+}
+
+// CompactHistory rebuilds the validity ranges of the given products and customers.
+// Consecutive versions with identical attributes are merged, each version is valid
+// until the next one starts, and the latest version is flagged as current. Only the
+// keys a load observed can have gained versions, so the rest of the history is left
+// unread.
+func (r *MongoRepository) CompactHistory(ctx context.Context, productIDs, customerIDs []string) error {
+	for _, compaction := range []struct {
+		entity versionedEntity
+		keys   []string
+	}{
+		{productHistory, productIDs},
+		{customerHistory, customerIDs},
+	} {
+		if err := r.compactHistory(ctx, compaction.entity, compaction.keys); err != nil {
+			return fmt.Errorf("failed to compact %s history: %w", compaction.entity.collection, err)
+		}
+	}
+	return nil
+}
+
+// compactHistory compacts the versions of keys, historyBatchSize keys per query
+func (r *MongoRepository) compactHistory(ctx context.Context, entity versionedEntity, keys []string) error {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	for start := 0; start < len(keys); start += historyBatchSize {
+		end := min(start+historyBatchSize, len(keys))
+		if err := r.compactKeys(ctx, entity, keys[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MongoRepository) compactKeys(ctx context.Context, entity versionedEntity, keys []string) error {
+	coll := r.GetCollection(entity.collection)
+
+	opts := options.Find().SetSort(bson.D{{Key: entity.key, Value: 1}, {Key: "valid_from", Value: 1}})
+	cursor, err := coll.Find(ctx, bson.M{entity.key: bson.M{"$in": keys}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var writes []mongo.WriteModel
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		return err
+	}
+
+	// kept holds the versions of the current key that survive the merge
+	var kept []bson.M
+	settle := func() error {
+		for i, version := range kept {
+			var validTo interface{}
+			if i+1 < len(kept) {
+				validTo = kept[i+1]["valid_from"]
+			}
+			isCurrent := i+1 == len(kept)
+
+			if version["valid_to"] != validTo || version["is_current"] != isCurrent {
+				writes = append(writes, mongo.NewUpdateOneModel().
+					SetFilter(bson.M{"_id": version["_id"]}).
+					SetUpdate(bson.M{"$set": bson.M{"valid_to": validTo, "is_current": isCurrent}}))
+			}
+		}
+		kept = kept[:0]
+
+		if len(writes) >= historyBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var version bson.M
+		if err := cursor.Decode(&version); err != nil {
+			return err
+		}
+
+		if len(kept) > 0 && kept[0][entity.key] != version[entity.key] {
+			if err := settle(); err != nil {
+				return err
+			}
+		}
+
+		if len(kept) > 0 && sameAttributes(kept[len(kept)-1], version, entity.attributes) {
+			writes = append(writes, mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": version["_id"]}))
+			continue
+		}
+		kept = append(kept, version)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if err := settle(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// sameAttributes reports whether two versions carry the same tracked attributes
+func sameAttributes(a, b bson.M, attributes []string) bool {
+	for _, attr := range attributes {
+		if a[attr] != b[attr] {
+			return false
+		}
+	}
+	return true
+}
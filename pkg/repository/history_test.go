@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// productVersions returns the versions of a product, oldest first
+func productVersions(tb testing.TB, repo *MongoRepository, productID string) []Product {
+	tb.Helper()
+
+	ctx := context.Background()
+	cursor, err := repo.GetCollection("products").Find(ctx, bson.M{"product_id": productID},
+		options.Find().SetSort(bson.D{{Key: "valid_from", Value: 1}}))
+	if err != nil {
+		tb.Fatalf("Find failed: %v", err)
+	}
+	var versions []Product
+	if err := cursor.All(ctx, &versions); err != nil {
+		tb.Fatalf("decoding products failed: %v", err)
+	}
+	return versions
+}
+
+// saleRow is a CSV row selling one unit of productID at price on date
+func saleRow(orderID, productID, date, price string) []string {
+	return []string{orderID, productID, "C1", "Widget", "Tools", "Europe", date, "1", price, "0", "5.00", "PayPal", "Ann", "ann@example.com", "1 Main St"}
+}
+
+func TestHistoryKeepsOneVersionWhileAttributesAreUnchanged(t *testing.T) {
+	repo, cfg := newTestRepository(t)
+
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{
+		saleRow("1", "P1", "2024-01-10", "100.00"),
+		saleRow("2", "P1", "2024-02-10", "100.00"),
+		saleRow("3", "P1", "2024-03-10", "100.00"),
+	}))
+
+	versions := productVersions(t, repo, "P1")
+	if len(versions) != 1 {
+		t.Fatalf("got %d versions, want 1: %+v", len(versions), versions)
+	}
+	v := versions[0]
+	if !v.ValidFrom.Equal(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)) || v.ValidTo != nil || !v.IsCurrent {
+		t.Errorf("version = %+v, want a current version valid from 2024-01-10 without end", v)
+	}
+}
+
+func TestHistoryOpensAVersionWhenAnAttributeChanges(t *testing.T) {
+	repo, cfg := newTestRepository(t)
+
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{
+		saleRow("1", "P1", "2024-01-10", "100.00"),
+		saleRow("2", "P1", "2024-02-10", "100.00"),
+	}))
+	// Repriced in March, in a later load
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{
+		saleRow("3", "P1", "2024-03-10", "120.00"),
+	}))
+
+	versions := productVersions(t, repo, "P1")
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want 2: %+v", len(versions), versions)
+	}
+	march := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	old, current := versions[0], versions[1]
+	if old.UnitPrice != 100 || old.IsCurrent || old.ValidTo == nil || !old.ValidTo.Equal(march) {
+		t.Errorf("old version = %+v, want price 100 closed on 2024-03-10", old)
+	}
+	if current.UnitPrice != 120 || !current.IsCurrent || current.ValidTo != nil || !current.ValidFrom.Equal(march) {
+		t.Errorf("current version = %+v, want price 120 open from 2024-03-10", current)
+	}
+}
+
+func TestHistoryCompactsOnlyLoadedKeys(t *testing.T) {
+	repo, cfg := newTestRepository(t)
+	ctx := context.Background()
+
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{saleRow("1", "P1", "2024-01-10", "100.00")}))
+
+	// Tamper with P1; a load that does not mention P1 must not read or rewrite it
+	if _, err := repo.GetCollection("products").UpdateOne(ctx, bson.M{"product_id": "P1"}, bson.M{"$set": bson.M{"is_current": false}}); err != nil {
+		t.Fatalf("UpdateOne failed: %v", err)
+	}
+	loadCSV(t, repo, cfg, writeCSV(t, [][]string{saleRow("2", "P2", "2024-01-11", "50.00")}))
+
+	if v := productVersions(t, repo, "P1"); len(v) != 1 || v[0].IsCurrent {
+		t.Errorf("P1 versions = %+v, want the untouched version", v)
+	}
+	if v := productVersions(t, repo, "P2"); len(v) != 1 || !v[0].IsCurrent {
+		t.Errorf("P2 versions = %+v, want one current version", v)
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLeaseLost is returned when renewing a lease that has been taken over by another holder
+var ErrLeaseLost = errors.New("lease lost")
+
+// Lease  exclusive, expiring lock shared by all API replicas
+type Lease struct {
+	Name       string             `bson:"_id" json:"name"`
+	Holder     string             `bson:"holder" json:"holder"`
+	JobID      primitive.ObjectID `bson:"job_id" json:"job_id"`
+	AcquiredAt time.Time          `bson:"acquired_at" json:"acquired_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// AcquireLease takes the named lease for holder if it is free, expired or already held by holder.
+// When another holder owns the lease it returns false together with the current lease.
+func (r *MongoRepository) AcquireLease(ctx context.Context, name, holder string, jobID primitive.ObjectID, ttl time.Duration) (bool, *Lease, error) {
+	coll := r.GetCollection("leases")
+	now := time.Now()
+
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": now}},
+			bson.M{"holder": holder},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"holder":      holder,
+		"job_id":      jobID,
+		"acquired_at": now,
+		"expires_at":  now.Add(ttl),
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var lease Lease
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&lease)
+	if err == nil {
+		return true, &lease, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, nil, err
+	}
+
+	// The upsert collided with a live lease owned by someone else
+	if err := coll.FindOne(ctx, bson.M{"_id": name}).Decode(&lease); err != nil {
+		return false, nil, err
+	}
+	return false, &lease, nil
+}
+
+// RenewLease extends a lease held by holder
+func (r *MongoRepository) RenewLease(ctx context.Context, name, holder string, ttl time.Duration) error {
+	result, err := r.GetCollection("leases").UpdateOne(ctx,
+		bson.M{"_id": name, "holder": holder},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(ttl)}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ReleaseLease gives up a lease held by holder
+func (r *MongoRepository) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := r.GetCollection("leases").DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLeaseLifecycle(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+
+	acquired, lease, err := repo.AcquireLease(ctx, "test", "a", first, time.Minute)
+	if err != nil || !acquired || lease.Holder != "a" || lease.JobID != first {
+		t.Fatalf("AcquireLease(a) = %v, %+v, %v; want the free lease", acquired, lease, err)
+	}
+
+	// A live lease is not handed to another holder, who learns who holds it
+	acquired, lease, err = repo.AcquireLease(ctx, "test", "b", second, time.Minute)
+	if err != nil || acquired || lease.Holder != "a" || lease.JobID != first {
+		t.Fatalf("AcquireLease(b) = %v, %+v, %v; want a refusal naming a", acquired, lease, err)
+	}
+
+	// The holder may take it again, for a new job
+	acquired, lease, err = repo.AcquireLease(ctx, "test", "a", second, time.Minute)
+	if err != nil || !acquired || lease.JobID != second {
+		t.Fatalf("AcquireLease(a) again = %v, %+v, %v; want the lease for the new job", acquired, lease, err)
+	}
+
+	if err := repo.RenewLease(ctx, "test", "a", time.Minute); err != nil {
+		t.Fatalf("RenewLease(a) failed: %v", err)
+	}
+	if err := repo.RenewLease(ctx, "test", "b", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("RenewLease(b) error = %v, want ErrLeaseLost", err)
+	}
+
+	// Releasing as another holder leaves the lease alone
+	if err := repo.ReleaseLease(ctx, "test", "b"); err != nil {
+		t.Fatalf("ReleaseLease(b) failed: %v", err)
+	}
+	if acquired, _, _ := repo.AcquireLease(ctx, "test", "b", second, time.Minute); acquired {
+		t.Fatal("lease was released by a holder that did not own it")
+	}
+
+	if err := repo.ReleaseLease(ctx, "test", "a"); err != nil {
+		t.Fatalf("ReleaseLease(a) failed: %v", err)
+	}
+	if acquired, _, err := repo.AcquireLease(ctx, "test", "b", second, time.Minute); err != nil || !acquired {
+		t.Fatalf("AcquireLease(b) after release = %v, %v; want the lease", acquired, err)
+	}
+}
+
+func TestExpiredLeaseIsHandedOver(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	if acquired, _, err := repo.AcquireLease(ctx, "test", "a", primitive.NewObjectID(), 50*time.Millisecond); err != nil || !acquired {
+		t.Fatalf("AcquireLease(a) = %v, %v; want the free lease", acquired, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// a crashed without releasing; b takes over once the lease expires
+	job := primitive.NewObjectID()
+	acquired, lease, err := repo.AcquireLease(ctx, "test", "b", job, time.Minute)
+	if err != nil || !acquired || lease.Holder != "b" || lease.JobID != job {
+		t.Fatalf("AcquireLease(b) = %v, %+v, %v; want the expired lease", acquired, lease, err)
+	}
+
+	// a learns that it lost the lease when it tries to renew
+	if err := repo.RenewLease(ctx, "test", "a", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("RenewLease(a) error = %v, want ErrLeaseLost", err)
+	}
+}
@@ -3,15 +3,22 @@ package repository
 import (
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
-	"strconv"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"sales_analytics/config"
+
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -19,66 +26,182 @@ import (
 type DataLoader struct {
 	repo       *MongoRepository
 	workerSize int
+	batchSize  int
+	columns    ColumnMapping
 }
 
 // NewDataLoader creates a new data loader
-func NewDataLoader(repo *MongoRepository, workerSize int) *DataLoader {
+func NewDataLoader(repo *MongoRepository, cfg *config.Config) *DataLoader {
 	return &DataLoader{
 		repo:       repo,
-		workerSize: workerSize,
+		workerSize: cfg.WorkerPoolSize,
+		batchSize:  max(cfg.InsertBatchSize, 1),
+		columns:    NewColumnMapping(cfg.CSVColumnAliases),
+	}
+}
+
+// csvRow  raw CSV row with its line number in the file
+type csvRow struct {
+	line   int
+	fields []string
+}
+
+// progressInterval is how often a running refresh stores its progress
+const progressInterval = 2 * time.Second
+
+// loadRun  state shared by the workers of a single refresh
+type loadRun struct {
+	refreshID  primitive.ObjectID
+	cols       columnIndex
+	startTime  time.Time
+	totalBytes int64
+	bytesRead  atomic.Int64
+	rowsRead   atomic.Int64
+	accepted   atomic.Int64
+	rejected   atomic.Int64
+
+	touchedMu sync.Mutex
+	products  map[string]struct{} // product IDs observed by the run
+	customers map[string]struct{} // customer IDs observed by the run
+}
+
+// touch records the products and customers of written records
+func (run *loadRun) touch(records []ParsedRecord) {
+	run.touchedMu.Lock()
+	defer run.touchedMu.Unlock()
+
+	if run.products == nil {
+		run.products = make(map[string]struct{})
+		run.customers = make(map[string]struct{})
+	}
+	for _, record := range records {
+		run.products[record.Product.ProductID] = struct{}{}
+		run.customers[record.Customer.CustomerID] = struct{}{}
+	}
+}
+
+// touched returns the product and customer IDs observed by the run
+func (run *loadRun) touched() (productIDs, customerIDs []string) {
+	run.touchedMu.Lock()
+	defer run.touchedMu.Unlock()
+
+	return slices.Collect(maps.Keys(run.products)), slices.Collect(maps.Keys(run.customers))
+}
+
+// progress returns a snapshot of the run, extrapolating the ETA from the bytes consumed so far
+func (run *loadRun) progress() RefreshProgress {
+	p := RefreshProgress{
+		RowsRead:    run.rowsRead.Load(),
+		RowsWritten: run.accepted.Load(),
+		BytesRead:   run.bytesRead.Load(),
+		TotalBytes:  run.totalBytes,
+		UpdatedAt:   time.Now(),
 	}
+
+	if p.BytesRead > 0 && p.TotalBytes > p.BytesRead {
+		elapsed := p.UpdatedAt.Sub(run.startTime)
+		remaining := time.Duration(float64(elapsed) * float64(p.TotalBytes-p.BytesRead) / float64(p.BytesRead))
+		eta := p.UpdatedAt.Add(remaining)
+		p.ETA = &eta
+	}
+	return p
+}
+
+// countingReader counts the bytes consumed from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
 }
 
-// LoadCSV loads CSV data into MongoDB using a worker pool
-func (dl *DataLoader) LoadCSV(ctx context.Context, filepath string) error {
-	startTime := time.Now()
+// LoadCSV loads CSV data into MongoDB using a worker pool and records the outcome in
+// the refresh log created by CreateRefreshLog. Cancelling ctx stops the load and
+// marks the refresh as cancelled.
+func (dl *DataLoader) LoadCSV(ctx context.Context, refreshID primitive.ObjectID, filepath string) error {
+	run := &loadRun{refreshID: refreshID, startTime: time.Now()}
+	err := dl.load(ctx, run, filepath)
+	return dl.finishRefresh(ctx, run, err)
+}
 
+// load streams the CSV file through the worker pool
+func (dl *DataLoader) load(ctx context.Context, run *loadRun, filepath string) error {
 	// Open CSV file
 	file, err := os.Open(filepath)
 	if err != nil {
-		return dl.logRefresh(ctx, startTime, "failed", 0, fmt.Sprintf("failed to open file: %v", err))
+		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	if info, err := file.Stat(); err == nil {
+		run.totalBytes = info.Size()
+	}
+
+	reader := csv.NewReader(countingReader{r: file, n: &run.bytesRead})
+	reader.FieldsPerRecord = -1 // row width is checked against the resolved columns
 
 	// Read header
 	header, err := reader.Read()
 	if err != nil {
-		return dl.logRefresh(ctx, startTime, "failed", 0, fmt.Sprintf("failed to read header: %v", err))
+		return fmt.Errorf("failed to read header: %w", err)
 	}
 
 	log.Printf("CSV Header: %v", header)
 
-	// Create channels for worker pool
-	recordChan := make(chan CSVRecord, dl.workerSize*2)
+	// Resolve columns by header name
+	run.cols, err = dl.columns.resolve(header)
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	// The first failure stops the reader and the remaining workers
+	loadCtx, cancelLoad := context.WithCancel(ctx)
+	defer cancelLoad()
+
 	errorChan := make(chan error, 1)
+	fail := func(err error) {
+		sendError(errorChan, err)
+		cancelLoad()
+	}
+
+	stopProgress := dl.reportProgress(loadCtx, run)
+	defer stopProgress()
+
+	// Create channels for worker pool
+	rowChan := make(chan csvRow, dl.workerSize*2)
 	var wg sync.WaitGroup
 
 	// Start workers
 	for i := 0; i < dl.workerSize; i++ {
 		wg.Add(1)
-		go dl.worker(ctx, i+1, recordChan, errorChan, &wg)
+		go dl.worker(loadCtx, i+1, run, rowChan, fail, &wg)
 	}
 
-	// Read and send records to workers
-	rowCount := 0
+	// Read and send rows to workers
 	go func() {
+		defer close(rowChan)
 		for {
-			row, err := reader.Read()
+			fields, err := reader.Read()
 			if err == io.EOF {
-				break
+				return
 			}
 			if err != nil {
-				errorChan <- fmt.Errorf("error reading row: %w", err)
-				break
+				fail(fmt.Errorf("error reading row: %w", err))
+				return
 			}
 
-			record := dl.parseCSVRow(row)
-			recordChan <- record
-			rowCount++
+			line, _ := reader.FieldPos(0)
+			select {
+			case rowChan <- csvRow{line: line, fields: fields}:
+				run.rowsRead.Add(1)
+			case <-loadCtx.Done():
+				return
+			}
 		}
-		close(recordChan)
 	}()
 
 	// Wait for workers to complete
@@ -87,141 +210,238 @@ func (dl *DataLoader) LoadCSV(ctx context.Context, filepath string) error {
 
 	// Check for errors
 	if err := <-errorChan; err != nil {
-		return dl.logRefresh(ctx, startTime, "failed", rowCount, err.Error())
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	log.Printf("Successfully loaded %d rows in %v", rowCount, time.Since(startTime))
-	return dl.logRefresh(ctx, startTime, "success", rowCount, "")
+	// Settle the validity ranges of the product and customer versions just observed
+	productIDs, customerIDs := run.touched()
+	return dl.repo.CompactHistory(ctx, productIDs, customerIDs)
 }
 
-// worker processes CSV records
-func (dl *DataLoader) worker(ctx context.Context, id int, records <-chan CSVRecord, errors chan<- error, wg *sync.WaitGroup) {
-	defer wg.Done()
+// reportProgress periodically stores the run's progress until the returned stop function is called
+func (dl *DataLoader) reportProgress(ctx context.Context, run *loadRun) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
 
-	for record := range records {
-		if err := dl.processRecord(ctx, record); err != nil {
-			log.Printf("Worker %d: Error processing record %s: %v", id, record.OrderID, err)
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
 			select {
-			case errors <- err:
-			default:
+			case <-ticker.C:
+				if err := dl.repo.UpdateRefreshProgress(ctx, run.refreshID, run.progress()); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to update progress of refresh %s: %v", run.refreshID.Hex(), err)
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
 			}
-			return
 		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
 	}
 }
 
-func (dl *DataLoader) processRecord(ctx context.Context, record CSVRecord) error {
+// finishRefresh records the final status of the run and returns the load error, if any
+func (dl *DataLoader) finishRefresh(ctx context.Context, run *loadRun, loadErr error) error {
+	status, errorMsg := RefreshSuccess, ""
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		status, errorMsg, loadErr = RefreshCancelled, "refresh cancelled", ctx.Err()
+		if cause := context.Cause(ctx); cause != context.Canceled {
+			errorMsg = fmt.Sprintf("refresh cancelled: %v", cause)
+		}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status, errorMsg, loadErr = RefreshFailed, "refresh timed out", ctx.Err()
+	case loadErr != nil:
+		status, errorMsg = RefreshFailed, loadErr.Error()
+	}
+
+	progress := run.progress()
+	progress.ETA = nil
+
+	refreshLog := RefreshLog{
+		ID:           run.refreshID,
+		EndTime:      time.Now(),
+		Status:       status,
+		RowsLoaded:   int(progress.RowsRead),
+		RowsAccepted: int(run.accepted.Load()),
+		RowsRejected: int(run.rejected.Load()),
+		ErrorMsg:     errorMsg,
+		Progress:     progress,
+	}
 
-	// ----------- CUSTOMER  -------------
-	customerColl := dl.repo.GetCollection("customers")
+	// ctx may already be done, so the outcome is recorded independently of it
+	logCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
 
-	customer := Customer{
-		CustomerID: record.CustomerID,
-		Name:       record.CustomerName,
-		Email:      record.CustomerEmail,
-		Address:    record.CustomerAddr,
+	if err := dl.repo.FinishRefreshLog(logCtx, refreshLog); err != nil {
+		return fmt.Errorf("failed to record refresh outcome: %w", err)
 	}
 
-	_, err := customerColl.UpdateOne( // Insert Only If Not Exists
-		ctx,
-		bson.M{"customer_id": customer.CustomerID},
-		bson.M{"$setOnInsert": customer},
-		options.Update().SetUpsert(true),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to upsert customer: %w", err)
+	if status == RefreshSuccess {
+		log.Printf("Successfully loaded %d rows (%d accepted, %d rejected) in %v",
+			refreshLog.RowsLoaded, refreshLog.RowsAccepted, refreshLog.RowsRejected, time.Since(run.startTime))
 	}
+	return loadErr
+}
 
-	// ----------- PRODUCT  -------------
-	productColl := dl.repo.GetCollection("products")
+// worker validates CSV rows and writes them in batches
+func (dl *DataLoader) worker(ctx context.Context, id int, run *loadRun, rows <-chan csvRow, fail func(error), wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	unitPrice, _ := strconv.ParseFloat(record.UnitPrice, 64)
-	discount, _ := strconv.ParseFloat(record.Discount, 64)
+	records := make([]ParsedRecord, 0, dl.batchSize)
+	var rejected []RejectedRow
 
-	product := Product{
-		ProductID: record.ProductID,
-		Name:      record.ProductName,
-		Category:  record.Category,
-		UnitPrice: unitPrice,
-		Discount:  discount,
+	flush := func() error {
+		if err := dl.writeBatch(ctx, records); err != nil {
+			return err
+		}
+		run.touch(records)
+		run.accepted.Add(int64(len(records)))
+		records = records[:0]
+
+		if err := dl.writeRejected(ctx, rejected); err != nil {
+			return err
+		}
+		run.rejected.Add(int64(len(rejected)))
+		rejected = rejected[:0]
+		return nil
 	}
 
-	_, err = productColl.UpdateOne( // Insert Only If Not Exists
-		ctx,
-		bson.M{"product_id": product.ProductID},
-		bson.M{"$setOnInsert": product},
-		options.Update().SetUpsert(true),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to upsert product: %w", err)
+	for row := range rows {
+		record, reasons := dl.parseRow(row, run.cols)
+		if reasons != nil {
+			rejected = append(rejected, RejectedRow{
+				RefreshID:  run.refreshID,
+				LineNumber: row.line,
+				RawRow:     row.fields,
+				Reasons:    reasons,
+				RejectedAt: time.Now(),
+			})
+		} else {
+			records = append(records, record)
+		}
+
+		if len(records)+len(rejected) >= dl.batchSize {
+			if err := flush(); err != nil {
+				log.Printf("Worker %d: Error writing batch ending at line %d: %v", id, row.line, err)
+				fail(err)
+				return
+			}
+		}
 	}
 
-	// ----------- ORDER  -------------
-	orderColl := dl.repo.GetCollection("orders")
+	if err := flush(); err != nil {
+		log.Printf("Worker %d: Error writing final batch: %v", id, err)
+		fail(err)
+	}
+}
 
-	dateOfSale, err := time.Parse("2006-01-02", record.DateOfSale)
+// parseRow maps and validates a raw row, returning the rejection reasons if any
+func (dl *DataLoader) parseRow(row csvRow, cols columnIndex) (ParsedRecord, []string) {
+	record, err := dl.parseCSVRow(row.fields, cols)
 	if err != nil {
-		return fmt.Errorf("failed to parse order date: %w", err)
+		return ParsedRecord{}, []string{err.Error()}
 	}
+	return ValidateRecord(record)
+}
 
-	quantitySold, _ := strconv.Atoi(record.QuantitySold)
-	shippingCost, _ := strconv.ParseFloat(record.ShippingCost, 64)
+// writeRejected stores rows that failed validation in the rejected_rows collection
+func (dl *DataLoader) writeRejected(ctx context.Context, rejected []RejectedRow) error {
+	if len(rejected) == 0 {
+		return nil
+	}
 
-	order := Order{
-		OrderID:       record.OrderID,
-		ProductID:     record.ProductID,
-		CustomerID:    record.CustomerID,
-		Region:        record.Region,
-		DateOfSale:    dateOfSale,
-		QuantitySold:  quantitySold,
-		ShippingCost:  shippingCost,
-		PaymentMethod: record.PaymentMethod,
+	docs := make([]interface{}, len(rejected))
+	for i := range rejected {
+		docs[i] = rejected[i]
 	}
 
-	_, err = orderColl.UpdateOne( // Insert Only If Not Exists
-		ctx,
-		bson.M{"order_id": order.OrderID},
-		bson.M{"$setOnInsert": order},
-		options.Update().SetUpsert(true),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to upsert order: %w", err)
+	if _, err := dl.repo.GetCollection("rejected_rows").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to store rejected rows: %w", err)
+	}
+	return nil
+}
+
+// writeBatch upserts a batch of records with one unordered BulkWrite per collection.
+// Each record is an observation of its product and customer on the date of sale;
+// observations repeated within the batch are written once.
+func (dl *DataLoader) writeBatch(ctx context.Context, records []ParsedRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	type observation struct {
+		key  string
+		date time.Time
+	}
+
+	var customers, products, orders []mongo.WriteModel
+	seenCustomers := make(map[observation]bool)
+	seenProducts := make(map[observation]bool)
+
+	for _, record := range records {
+		if c := (observation{record.Customer.CustomerID, record.Customer.ValidFrom}); !seenCustomers[c] {
+			seenCustomers[c] = true
+			customers = append(customers, customerVersion(record.Customer))
+		}
+		if p := (observation{record.Product.ProductID, record.Product.ValidFrom}); !seenProducts[p] {
+			seenProducts[p] = true
+			products = append(products, productVersion(record.Product))
+		}
+		orders = append(orders, insertIfMissing("order_id", record.Order.OrderID, record.Order))
+	}
+
+	opts := options.BulkWrite().SetOrdered(false)
+
+	if _, err := dl.repo.GetCollection("customers").BulkWrite(ctx, customers, opts); err != nil {
+		return fmt.Errorf("failed to upsert customers: %w", err)
+	}
+	if _, err := dl.repo.GetCollection("products").BulkWrite(ctx, products, opts); err != nil {
+		return fmt.Errorf("failed to upsert products: %w", err)
+	}
+	if _, err := dl.repo.GetCollection("orders").BulkWrite(ctx, orders, opts); err != nil {
+		return fmt.Errorf("failed to upsert orders: %w", err)
 	}
 
 	return nil
 }
 
-// parseCSVRow parses a CSV row into a CSVRecord
-func (dl *DataLoader) parseCSVRow(row []string) CSVRecord {
-	return CSVRecord{
-		OrderID:       row[0],
-		ProductID:     row[1],
-		CustomerID:    row[2],
-		ProductName:   row[3],
-		Category:      row[4],
-		Region:        row[5],
-		DateOfSale:    row[6],
-		QuantitySold:  row[7],
-		UnitPrice:     row[8],
-		Discount:      row[9],
-		ShippingCost:  row[10],
-		PaymentMethod: row[11],
-		CustomerName:  row[12],
-		CustomerEmail: row[13],
-		CustomerAddr:  row[14],
-	}
-}
-
-// logRefresh logs the data refresh operation
-func (dl *DataLoader) logRefresh(ctx context.Context, startTime time.Time, status string, rowsLoaded int, errorMsg string) error {
-	refreshLog := RefreshLog{
-		StartTime:  startTime,
-		EndTime:    time.Now(),
-		Status:     status,
-		RowsLoaded: rowsLoaded,
-		ErrorMsg:   errorMsg,
+// insertIfMissing builds an upsert that only writes the document when no document matches key
+func insertIfMissing(key, value string, doc interface{}) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{key: value}).
+		SetUpdate(bson.M{"$setOnInsert": doc}).
+		SetUpsert(true)
+}
+
+// parseCSVRow parses a CSV row into a CSVRecord using the resolved header columns
+func (dl *DataLoader) parseCSVRow(row []string, cols columnIndex) (CSVRecord, error) {
+	if width := cols.width(); len(row) < width {
+		return CSVRecord{}, fmt.Errorf("row has %d fields, expected at least %d", len(row), width)
 	}
 
-	_, err := dl.repo.GetCollection("refresh_logs").InsertOne(ctx, refreshLog)
-	return err
+	var record CSVRecord
+	for field, idx := range cols {
+		fieldSetters[field](&record, row[idx])
+	}
+	return record, nil
+}
+
+// sendError reports an error without blocking when one is already pending
+func sendError(errs chan<- error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
 }
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sales_analytics/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestRepository connects to the MongoDB instance in MONGODB_TEST_URI using a
+// throwaway database that is dropped when the test finishes
+func newTestRepository(tb testing.TB) (*MongoRepository, *config.Config) {
+	tb.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		tb.Skip("MONGODB_TEST_URI not set, skipping MongoDB test")
+	}
+
+	cfg := &config.Config{
+		MongoURI:        uri,
+		DatabaseName:    fmt.Sprintf("sales_analytics_test_%d", time.Now().UnixNano()),
+		WorkerPoolSize:  4,
+		InsertBatchSize: 1000,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	repo, err := NewMongoRepository(ctx, cfg)
+	if err != nil {
+		tb.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = repo.db.Drop(ctx)
+		_ = repo.Disconnect(ctx)
+	})
+
+	return repo, cfg
+}
+
+// testCSVHeader is the header of the sales CSV files written by the tests
+var testCSVHeader = []string{
+	"Order ID", "Product ID", "Customer ID", "Product Name", "Category", "Region",
+	"Date of Sale", "Quantity Sold", "Unit Price", "Discount", "Shipping Cost",
+	"Payment Method", "Customer Name", "Customer Email", "Customer Address",
+}
+
+// writeCSV writes testCSVHeader followed by rows to a temporary file
+func writeCSV(tb testing.TB, rows [][]string) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "sales.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatalf("failed to create CSV: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	_ = w.Write(testCSVHeader)
+	_ = w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		tb.Fatalf("failed to write CSV: %v", err)
+	}
+	return path
+}
+
+// loadCSV runs a refresh of path and fails the test if it does not succeed
+func loadCSV(tb testing.TB, repo *MongoRepository, cfg *config.Config, path string) {
+	tb.Helper()
+
+	ctx := context.Background()
+	refreshID := primitive.NewObjectID()
+	if err := repo.CreateRefreshLog(ctx, refreshID, "test", RefreshRunning); err != nil {
+		tb.Fatalf("CreateRefreshLog failed: %v", err)
+	}
+	if err := NewDataLoader(repo, cfg).LoadCSV(ctx, refreshID, path); err != nil {
+		tb.Fatalf("LoadCSV failed: %v", err)
+	}
+}
+
+// writeSalesCSV generates a CSV file with rows orders spread over a fixed set of products and customers
+func writeSalesCSV(tb testing.TB, rows int) string {
+	tb.Helper()
+
+	records := make([][]string, 0, rows)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < rows; i++ {
+		product := i % 100
+		customer := i % 500
+		records = append(records, []string{
+			fmt.Sprintf("O%07d", i),
+			fmt.Sprintf("P%03d", product),
+			fmt.Sprintf("C%04d", customer),
+			fmt.Sprintf("Product %d", product),
+			[]string{"Electronics", "Shoes", "Books", "Toys"}[product%4],
+			[]string{"North America", "Europe", "Asia", "South America"}[customer%4],
+			start.AddDate(0, 0, i%365).Format("2006-01-02"),
+			fmt.Sprint(1 + i%5),
+			fmt.Sprintf("%d.99", 10+product),
+			"0.1",
+			"5.00",
+			[]string{"Credit Card", "PayPal", "Debit Card"}[i%3],
+			fmt.Sprintf("Customer %d", customer),
+			fmt.Sprintf("customer%d@example.com", customer),
+			fmt.Sprintf("%d Main St", customer),
+		})
+	}
+	return writeCSV(tb, records)
+}
+
+// BenchmarkLoadCSV compares per-row writes (batch size 1, three round trips per
+// row like the original UpdateOne path) with batched BulkWrite ingestion
+func BenchmarkLoadCSV(b *testing.B) {
+	const rows = 20000
+
+	for _, batchSize := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("batch_%d", batchSize), func(b *testing.B) {
+			path := writeSalesCSV(b, rows)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				repo, cfg := newTestRepository(b)
+				cfg.InsertBatchSize = batchSize
+				b.StartTimer()
+
+				loadCSV(b, repo, cfg, path)
+			}
+			b.ReportMetric(float64(rows*b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackfillOrderPricing copies the product name, category, unit price and discount in
+// effect on each order's date_of_sale onto orders loaded before they were captured at
+// sale time, and computes their line revenue. It returns the number of orders
+// backfilled and the number still missing pricing because no product version matched.
+func (r *MongoRepository) BackfillOrderPricing(ctx context.Context) (int64, int64, error) {
+	orders := r.GetCollection("orders")
+	missing := bson.M{"line_revenue": bson.M{"$exists": false}}
+
+	before, err := orders.CountDocuments(ctx, missing)
+	if err != nil {
+		return 0, 0, err
+	}
+	if before == 0 {
+		return 0, 0, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: missing}},
+		productAtSaleLookup(),
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$project", Value: bson.M{
+			"product_name": "$product.name",
+			"category":     "$product.category",
+			"unit_price":   "$product.unit_price",
+			"discount":     "$product.discount",
+			"line_revenue": bson.M{"$multiply": bson.A{
+				"$quantity_sold",
+				bson.M{"$subtract": bson.A{
+					"$product.unit_price",
+					bson.M{"$multiply": bson.A{"$product.unit_price", "$product.discount"}},
+				}},
+			}},
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           "orders",
+			"on":             "_id",
+			"whenMatched":    "merge",
+			"whenNotMatched": "discard",
+		}}},
+	}
+
+	cursor, err := orders.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+	cursor.Close(ctx)
+
+	after, err := orders.CountDocuments(ctx, missing)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before - after, after, nil
+}
+
+// productAtSaleLookup joins each order with the product version in effect on its date_of_sale
+func productAtSaleLookup() bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.M{
+		"from": "products",
+		"let":  bson.M{"product_id": "$product_id", "sold_at": "$date_of_sale"},
+		"pipeline": mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"$expr": bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$product_id", "$$product_id"}},
+				bson.M{"$lte": bson.A{"$valid_from", "$$sold_at"}},
+				bson.M{"$or": bson.A{
+					bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$valid_to", nil}}, nil}},
+					bson.M{"$gt": bson.A{"$valid_to", "$$sold_at"}},
+				}},
+			}}}}},
+			{{Key: "$sort", Value: bson.M{"valid_from": -1}}},
+			{{Key: "$limit", Value: 1}},
+		},
+		"as": "product",
+	}}}
+}
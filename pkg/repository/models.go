@@ -6,16 +6,19 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Customer  customer entity
+// Customer  customer entity, one document per version of the customer's details
 type Customer struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty"`
 	CustomerID string             `bson:"customer_id" json:"customer_id"`
 	Name       string             `bson:"name" json:"name"`
 	Email      string             `bson:"email" json:"email"`
 	Address    string             `bson:"address" json:"address"`
+	ValidFrom  time.Time          `bson:"valid_from" json:"valid_from"`
+	ValidTo    *time.Time         `bson:"valid_to" json:"valid_to"` // nil for the current version
+	IsCurrent  bool               `bson:"is_current" json:"is_current"`
 }
 
-// Product  product entity
+// Product  product entity, one document per version of the product's price and details
 type Product struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	ProductID string             `bson:"product_id" json:"product_id"`
@@ -23,29 +26,64 @@ type Product struct {
 	Category  string             `bson:"category" json:"category"`
 	UnitPrice float64            `bson:"unit_price" json:"unit_price"`
 	Discount  float64            `bson:"discount" json:"discount"`
+	ValidFrom time.Time          `bson:"valid_from" json:"valid_from"`
+	ValidTo   *time.Time         `bson:"valid_to" json:"valid_to"` // nil for the current version
+	IsCurrent bool               `bson:"is_current" json:"is_current"`
 }
 
-// Order n order entity
+// Order n order entity. Product details, price and discount are captured at sale time.
 type Order struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty"`
 	OrderID       string             `bson:"order_id" json:"order_id"`
 	ProductID     string             `bson:"product_id" json:"product_id"`
 	CustomerID    string             `bson:"customer_id" json:"customer_id"`
+	ProductName   string             `bson:"product_name" json:"product_name"`
+	Category      string             `bson:"category" json:"category"`
 	Region        string             `bson:"region" json:"region"`
 	DateOfSale    time.Time          `bson:"date_of_sale" json:"date_of_sale"`
 	QuantitySold  int                `bson:"quantity_sold" json:"quantity_sold"`
+	UnitPrice     float64            `bson:"unit_price" json:"unit_price"`
+	Discount      float64            `bson:"discount" json:"discount"`
+	LineRevenue   float64            `bson:"line_revenue" json:"line_revenue"` // quantity × (unit price − unit price × discount)
 	ShippingCost  float64            `bson:"shipping_cost" json:"shipping_cost"`
 	PaymentMethod string             `bson:"payment_method" json:"payment_method"`
+	BasketID      string             `bson:"basket_id,omitempty" json:"basket_id,omitempty"` // groups order lines bought together
 }
 
 // RefreshLog  data refresh log entry
 type RefreshLog struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	StartTime  time.Time          `bson:"start_time" json:"start_time"`
-	EndTime    time.Time          `bson:"end_time" json:"end_time"`
-	Status     string             `bson:"status" json:"status"` // success, failed
-	RowsLoaded int                `bson:"rows_loaded" json:"rows_loaded"`
-	ErrorMsg   string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Trigger      string             `bson:"trigger,omitempty" json:"trigger,omitempty"` // api, cron
+	StartTime    time.Time          `bson:"start_time" json:"start_time"`
+	EndTime      time.Time          `bson:"end_time" json:"end_time"`
+	Status       string             `bson:"status" json:"status"` // queued, running, success, failed, cancelled
+	RowsLoaded   int                `bson:"rows_loaded" json:"rows_loaded"`
+	RowsAccepted int                `bson:"rows_accepted" json:"rows_accepted"`
+	RowsRejected int                `bson:"rows_rejected" json:"rows_rejected"`
+	ErrorMsg     string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	Progress     RefreshProgress    `bson:"progress" json:"progress"`
+	// CancelRequested asks the instance running the refresh to cancel it
+	CancelRequested bool `bson:"cancel_requested,omitempty" json:"cancel_requested,omitempty"`
+}
+
+// RefreshProgress  live progress of a refresh
+type RefreshProgress struct {
+	RowsRead    int64      `bson:"rows_read" json:"rows_read"`
+	RowsWritten int64      `bson:"rows_written" json:"rows_written"`
+	BytesRead   int64      `bson:"bytes_read" json:"bytes_read"`
+	TotalBytes  int64      `bson:"total_bytes" json:"total_bytes"`
+	ETA         *time.Time `bson:"eta,omitempty" json:"eta,omitempty"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// RejectedRow  CSV row that failed validation during a refresh
+type RejectedRow struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RefreshID  primitive.ObjectID `bson:"refresh_id" json:"refresh_id"`
+	LineNumber int                `bson:"line_number" json:"line_number"`
+	RawRow     []string           `bson:"raw_row" json:"raw_row"`
+	Reasons    []string           `bson:"reasons" json:"reasons"`
+	RejectedAt time.Time          `bson:"rejected_at" json:"rejected_at"`
 }
 
 // CSVRecord  row from the CSV file
@@ -65,4 +103,5 @@ type CSVRecord struct {
 	CustomerName  string
 	CustomerEmail string
 	CustomerAddr  string
+	BasketID      string
 }
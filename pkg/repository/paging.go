@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// GroupSort  field grouped revenue results are ordered by
+type GroupSort string
+
+// Supported group orderings
+const (
+	GroupSortRevenue  GroupSort = "revenue"
+	GroupSortQuantity GroupSort = "quantity"
+	GroupSortName     GroupSort = "name" // product name, or the group itself
+)
+
+// ParseGroupSort validates a group ordering name
+func ParseGroupSort(name string) (GroupSort, error) {
+	switch s := GroupSort(name); s {
+	case GroupSortRevenue, GroupSortQuantity, GroupSortName:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid sort_by %q, use revenue, quantity or name", name)
+}
+
+// GroupPage  ordering and window of grouped revenue results
+type GroupPage struct {
+	SortBy     GroupSort
+	Descending bool
+	Limit      int // 0 keeps every group after Offset
+	Offset     int
+}
+
+// OtherGroups  totals of the groups left out of a page
+type OtherGroups struct {
+	Groups int `json:"groups"`
+	RevenueMetrics
+	Comparison *RevenueComparison `json:"comparison,omitempty"`
+}
+
+// PageGroups orders groups and cuts out the page. Ties keep their incoming order,
+// which is highest revenue first. The groups outside the page, before or after it,
+// are added up into others, so the page and others always account for every group;
+// others is nil when the page holds them all.
+func PageGroups[T any, P RevenueGroup[T]](groups []T, page GroupPage) (results []T, others *OtherGroups) {
+	slices.SortStableFunc(groups, func(a, b T) int {
+		pa, pb := P(&a), P(&b)
+		var c int
+		switch page.SortBy {
+		case GroupSortQuantity:
+			c = cmp.Compare(pa.metrics().Quantity, pb.metrics().Quantity)
+		case GroupSortName:
+			c = strings.Compare(pa.label(), pb.label())
+		default:
+			c = cmp.Compare(pa.revenue(), pb.revenue())
+		}
+		if page.Descending {
+			return -c
+		}
+		return c
+	})
+
+	start := min(page.Offset, len(groups))
+	end := len(groups)
+	if page.Limit > 0 {
+		end = min(start+page.Limit, end)
+	}
+	if start == 0 && end == len(groups) {
+		return groups, nil
+	}
+
+	others = &OtherGroups{}
+	var previous float64
+	compared := false
+	for _, outside := range [][]T{groups[:start], groups[end:]} {
+		for i := range outside {
+			p := P(&outside[i])
+			others.Groups++
+			others.add(p.metrics())
+			if c := p.comparison(); c != nil {
+				previous += c.PreviousRevenue
+				compared = true
+			}
+		}
+	}
+	if compared {
+		others.Comparison = NewRevenueComparison(others.TotalRevenue, previous)
+	}
+	return groups[start:end], others
+}
+
+// add sums the metrics of another group into o
+func (o *OtherGroups) add(m RevenueMetrics) {
+	o.TotalRevenue += m.TotalRevenue
+	o.GrossRevenue += m.GrossRevenue
+	o.TotalDiscount += m.TotalDiscount
+	o.NetRevenue += m.NetRevenue
+	o.ShippingRevenue += m.ShippingRevenue
+	o.Quantity += m.Quantity
+	o.OrderCount += m.OrderCount
+	o.AverageOrderValue = 0
+	if o.OrderCount != 0 {
+		o.AverageOrderValue = o.NetRevenue / float64(o.OrderCount)
+	}
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+// regions builds grouped results with the given revenues, one order per 100 of revenue
+func regions(revenues map[string]float64) []RegionRevenueResult {
+	var groups []RegionRevenueResult
+	for _, region := range slices.Sorted(maps.Keys(revenues)) {
+		groups = append(groups, RegionRevenueResult{
+			Region: region,
+			RevenueMetrics: RevenueMetrics{
+				TotalRevenue: revenues[region],
+				NetRevenue:   revenues[region],
+				OrderCount:   int(revenues[region] / 100),
+			},
+		})
+	}
+	return groups
+}
+
+func regionNames(groups []RegionRevenueResult) []string {
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.Region)
+	}
+	return names
+}
+
+func TestPageGroups(t *testing.T) {
+	revenues := map[string]float64{"Asia": 400, "Europe": 300, "Oceania": 200, "US": 100}
+
+	tests := []struct {
+		name       string
+		page       GroupPage
+		want       []string
+		wantOthers *OtherGroups
+	}{
+		{"every group", GroupPage{Descending: true}, []string{"Asia", "Europe", "Oceania", "US"}, nil},
+		{"first page", GroupPage{Descending: true, Limit: 2}, []string{"Asia", "Europe"},
+			&OtherGroups{Groups: 2, RevenueMetrics: RevenueMetrics{TotalRevenue: 300, NetRevenue: 300, OrderCount: 3, AverageOrderValue: 100}}},
+		{"groups before and after the page", GroupPage{Descending: true, Limit: 2, Offset: 1}, []string{"Europe", "Oceania"},
+			&OtherGroups{Groups: 2, RevenueMetrics: RevenueMetrics{TotalRevenue: 500, NetRevenue: 500, OrderCount: 5, AverageOrderValue: 100}}},
+		{"by name", GroupPage{SortBy: GroupSortName, Limit: 1, Offset: 3}, []string{"US"},
+			&OtherGroups{Groups: 3, RevenueMetrics: RevenueMetrics{TotalRevenue: 900, NetRevenue: 900, OrderCount: 9, AverageOrderValue: 100}}},
+		{"offset past the end", GroupPage{Descending: true, Limit: 2, Offset: 10}, []string{},
+			&OtherGroups{Groups: 4, RevenueMetrics: RevenueMetrics{TotalRevenue: 1000, NetRevenue: 1000, OrderCount: 10, AverageOrderValue: 100}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, others := PageGroups(regions(revenues), tt.page)
+			if got := regionNames(results); !slices.Equal(got, tt.want) {
+				t.Errorf("page = %v, want %v", got, tt.want)
+			}
+			switch {
+			case tt.wantOthers == nil && others != nil:
+				t.Errorf("others = %+v, want nil", others)
+			case tt.wantOthers != nil && (others == nil || *others != *tt.wantOthers):
+				t.Errorf("others = %+v, want %+v", others, tt.wantOthers)
+			}
+		})
+	}
+}
+
+func TestPageGroupsComparesOthers(t *testing.T) {
+	current := regions(map[string]float64{"Asia": 400, "Europe": 300, "US": 100})
+	previous := regions(map[string]float64{"Asia": 200, "Oceania": 100, "US": 300})
+
+	// Europe is the page; Asia before it, US and the previous only Oceania after it
+	results, others := PageGroups(CompareGroups(current, previous), GroupPage{Descending: true, Limit: 1, Offset: 1})
+	if got := regionNames(results); !slices.Equal(got, []string{"Europe"}) {
+		t.Fatalf("page = %v, want [Europe]", got)
+	}
+	if others == nil || others.Groups != 3 || others.TotalRevenue != 500 {
+		t.Fatalf("others = %+v, want 3 groups with 500 revenue", others)
+	}
+	if c := others.Comparison; c == nil || c.PreviousRevenue != 600 || c.Change != -100 {
+		t.Errorf("others comparison = %+v, want 600 previous revenue and a change of -100", c)
+	}
+}
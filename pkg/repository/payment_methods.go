@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PaymentMethodRevenueResult revenue by payment method
+type PaymentMethodRevenueResult struct {
+	PaymentMethod  string `bson:"_id" json:"payment_method"`
+	RevenueMetrics `bson:",inline"`
+	Share          float64            `bson:"share" json:"share"` // fraction of net revenue
+	Comparison     *RevenueComparison `bson:"-" json:"comparison,omitempty"`
+}
+
+// PaymentMethodSharePoint  a payment method's revenue in one time bucket
+type PaymentMethodSharePoint struct {
+	PeriodStart time.Time `json:"period_start"`
+	Revenue     float64   `json:"revenue"`
+	OrderCount  int       `json:"order_count"`
+	Share       float64   `json:"share"` // fraction of the bucket's net revenue
+}
+
+// PaymentMethodShareSeries  share of one payment method over time
+type PaymentMethodShareSeries struct {
+	PaymentMethod string                    `json:"payment_method"`
+	Points        []PaymentMethodSharePoint `json:"points"`
+}
+
+// CalculateRevenueByPaymentMethod revenue grouped by payment method
+func (r *MongoRepository) CalculateRevenueByPaymentMethod(ctx context.Context, startDate, endDate time.Time, filter bson.M) ([]PaymentMethodRevenueResult, error) {
+	totals, _, err := r.revenueBy(ctx, "payment_method", startDate, endDate, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var net float64
+	for _, t := range totals {
+		net += t.Values["revenue"]
+	}
+
+	results := make([]PaymentMethodRevenueResult, len(totals))
+	for i, t := range totals {
+		results[i] = PaymentMethodRevenueResult{PaymentMethod: t.Key[0], RevenueMetrics: newRevenueMetrics(t.Values)}
+		if net != 0 {
+			results[i].Share = results[i].NetRevenue / net
+		}
+	}
+	return results, nil
+}
+
+// CalculatePaymentMethodShare revenue share of each payment method per time bucket.
+// Buckets without sales are reported with zero revenue and share. Returns
+// ErrSeriesTooLarge when the zero-filled series would exceed maxSeriesPoints.
+func (r *MongoRepository) CalculatePaymentMethodShare(ctx context.Context, startDate, endDate time.Time, granularity Granularity, filter bson.M) ([]PaymentMethodShareSeries, error) {
+	if err := granularity.CheckRange(startDate, endDate); err != nil {
+		return nil, err
+	}
+
+	pivot, err := r.Pivot(ctx, PivotQuery{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Rows:      []string{"payment_method"},
+		Cols:      []string{string(granularity)},
+		Measures:  []string{"revenue", "orders"},
+		Filter:    filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bucketRevenue := make(map[string]float64)
+	for _, t := range pivot.ColTotals {
+		bucketRevenue[t.Key[0]] = t.Values["revenue"]
+	}
+
+	buckets := granularity.Buckets(startDate, endDate)
+	if len(buckets)*len(pivot.RowTotals) > maxSeriesPoints {
+		return nil, ErrSeriesTooLarge
+	}
+	bucketIndex := make(map[string]int, len(buckets))
+	for i, b := range buckets {
+		bucketIndex[b.Format("2006-01-02")] = i
+	}
+
+	series := make(map[string]*PaymentMethodShareSeries)
+	var methods []string
+	for _, cell := range pivot.Cells {
+		method := cell.Row[0]
+		s, ok := series[method]
+		if !ok {
+			s = &PaymentMethodShareSeries{PaymentMethod: method, Points: make([]PaymentMethodSharePoint, len(buckets))}
+			for i, b := range buckets {
+				s.Points[i].PeriodStart = b
+			}
+			series[method] = s
+			methods = append(methods, method)
+		}
+
+		i, ok := bucketIndex[cell.Col[0]]
+		if !ok {
+			continue
+		}
+		point := &s.Points[i]
+		point.Revenue = cell.Values["revenue"]
+		point.OrderCount = int(cell.Values["orders"])
+		if total := bucketRevenue[cell.Col[0]]; total != 0 {
+			point.Share = point.Revenue / total
+		}
+	}
+
+	sort.Strings(methods)
+	results := make([]PaymentMethodShareSeries, len(methods))
+	for i, method := range methods {
+		results[i] = *series[method]
+	}
+	return results, nil
+}
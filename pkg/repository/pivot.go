@@ -0,0 +1,420 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPivotGroups bounds the number of row and column combinations a pivot may produce
+const maxPivotGroups = 50000
+
+// ErrPivotTooLarge is returned when a pivot has more combinations than maxPivotGroups
+var ErrPivotTooLarge = fmt.Errorf("pivot has more than %d row and column combinations, narrow the date range or filters", maxPivotGroups)
+
+// pivotDimension describes an order attribute a pivot can group by
+type pivotDimension struct {
+	expr        interface{} // group key expression
+	label       string      // optional display name expression
+	granularity Granularity // set for time bucket dimensions
+}
+
+// pivotDimensions lists the dimensions accepted for pivot rows and columns
+var pivotDimensions = map[string]pivotDimension{
+	"region":         {expr: "$region"},
+	"category":       {expr: "$category"},
+	"product":        {expr: "$product_id", label: "$product_name"},
+	"payment_method": {expr: "$payment_method"},
+	"customer":       {expr: "$customer_id"},
+	"day":            {granularity: GranularityDay},
+	"week":           {granularity: GranularityWeek},
+	"month":          {granularity: GranularityMonth},
+	"quarter":        {granularity: GranularityQuarter},
+	"year":           {granularity: GranularityYear},
+}
+
+// PivotDimensions returns the dimension names accepted for rows and columns
+func PivotDimensions() []string {
+	names := make([]string, 0, len(pivotDimensions))
+	for name := range pivotDimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pivotMeasure is either summed over orders or derived from other measures
+type pivotMeasure struct {
+	sum    interface{}                           // per-order value summed into the measure
+	derive func(sums map[string]float64) float64 // computed from the summed measures
+}
+
+// grossExpr is the revenue of an order line before discount
+var grossExpr = bson.M{"$multiply": bson.A{"$quantity_sold", "$unit_price"}}
+
+// pivotMeasures lists the measures a pivot can compute
+var pivotMeasures = map[string]pivotMeasure{
+	"revenue":       {sum: "$line_revenue"},
+	"gross_revenue": {sum: grossExpr},
+	"discount":      {sum: bson.M{"$multiply": bson.A{grossExpr, "$discount"}}},
+	"shipping":      {sum: "$shipping_cost"},
+	"quantity":      {sum: "$quantity_sold"},
+	"orders":        {sum: 1},
+	"average_order_value": {derive: func(sums map[string]float64) float64 {
+		if sums["orders"] == 0 {
+			return 0
+		}
+		return sums["revenue"] / sums["orders"]
+	}},
+}
+
+// PivotMeasures returns the measure names a pivot can compute
+func PivotMeasures() []string {
+	names := make([]string, 0, len(pivotMeasures))
+	for name := range pivotMeasures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PivotQuery  parameters of a pivot
+type PivotQuery struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Rows      []string // row dimensions, outermost first
+	Cols      []string // column dimensions, outermost first
+	Measures  []string
+	Filter    bson.M
+
+	// unbounded lifts maxPivotGroups for grouped revenue, which returns one row per
+	// group and pages them itself
+	unbounded bool
+}
+
+// Validate checks the dimensions and measures of a pivot query
+func (q PivotQuery) Validate() error {
+	seen := make(map[string]bool)
+	for _, dim := range append(append([]string{}, q.Rows...), q.Cols...) {
+		if _, ok := pivotDimensions[dim]; !ok {
+			return fmt.Errorf("unknown dimension %q, use %s", dim, strings.Join(PivotDimensions(), ", "))
+		}
+		if seen[dim] {
+			return fmt.Errorf("dimension %q is used more than once", dim)
+		}
+		seen[dim] = true
+	}
+	if len(q.Measures) == 0 {
+		return errors.New("at least one measure is required")
+	}
+	for _, measure := range q.Measures {
+		if _, ok := pivotMeasures[measure]; !ok {
+			return fmt.Errorf("unknown measure %q, use %s", measure, strings.Join(PivotMeasures(), ", "))
+		}
+	}
+	return nil
+}
+
+// PivotCell  measures of one row and column combination
+type PivotCell struct {
+	Row    []string           `json:"row"`
+	Col    []string           `json:"col"`
+	Values map[string]float64 `json:"values"`
+}
+
+// PivotTotal  measures aggregated over every row or column sharing a key prefix.
+// Level is the number of dimensions fixed by Key; Level equal to the number of
+// dimensions is the total of a full row or column, lower levels are subtotals.
+type PivotTotal struct {
+	Key    []string           `json:"key"`
+	Level  int                `json:"level"`
+	Values map[string]float64 `json:"values"`
+}
+
+// PivotResult  result of a pivot
+type PivotResult struct {
+	Rows       []string                     `json:"rows"`
+	Cols       []string                     `json:"cols"`
+	Measures   []string                     `json:"measures"`
+	RowKeys    [][]string                   `json:"row_keys"`
+	ColKeys    [][]string                   `json:"col_keys"`
+	Cells      []PivotCell                  `json:"cells"`
+	RowTotals  []PivotTotal                 `json:"row_totals"`
+	ColTotals  []PivotTotal                 `json:"col_totals"`
+	GrandTotal map[string]float64           `json:"grand_total"`
+	Labels     map[string]map[string]string `json:"labels,omitempty"` // display names by dimension and key
+}
+
+// Pivot aggregates the orders in a date range by the row and column dimensions of q,
+// with subtotals for every row and column key prefix and a grand total. It reads
+// the daily rollup instead of orders when the query fits its grain.
+func (r *MongoRepository) Pivot(ctx context.Context, q PivotQuery) (*PivotResult, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	dims := append(append([]string{}, q.Rows...), q.Cols...)
+
+	var fields []string
+	for _, dim := range dims {
+		if expr, ok := pivotDimensions[dim].expr.(string); ok {
+			fields = append(fields, expr)
+		}
+	}
+	source := r.salesSourceFor(ctx, q.StartDate, q.EndDate, q.Filter, fields...)
+
+	id := bson.M{}
+	group := bson.M{}
+	for i, dim := range dims {
+		d := pivotDimensions[dim]
+		if d.granularity != "" {
+			id[dimKey(i)] = d.granularity.bucketExpr()
+		} else {
+			id[dimKey(i)] = d.expr
+		}
+		if d.label != "" {
+			group[labelKey(i)] = bson.M{"$first": d.label}
+		}
+	}
+	if len(id) > 0 {
+		group["_id"] = id
+	} else {
+		group["_id"] = nil
+	}
+	for name, measure := range pivotMeasures {
+		if measure.sum != nil {
+			group[name] = bson.M{"$sum": source.measure(name)}
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		matchOrders(q.StartDate, q.EndDate, q.Filter),
+		{{Key: "$group", Value: group}},
+	}
+	if !q.unbounded {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: maxPivotGroups + 1}})
+	}
+
+	cursor, err := r.GetCollection(source.collection).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []bson.M
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	return buildPivot(q, groups)
+}
+
+// buildPivot arranges grouped sums into cells, totals and subtotals. Returns
+// ErrPivotTooLarge when there are more groups than maxPivotGroups, unless the query
+// is unbounded.
+func buildPivot(q PivotQuery, groups []bson.M) (*PivotResult, error) {
+	if !q.unbounded && len(groups) > maxPivotGroups {
+		return nil, ErrPivotTooLarge
+	}
+
+	result := &PivotResult{
+		Rows:       nonNil(q.Rows),
+		Cols:       nonNil(q.Cols),
+		Measures:   q.Measures,
+		Cells:      make([]PivotCell, 0, len(groups)),
+		GrandTotal: make(map[string]float64, len(pivotMeasures)),
+	}
+
+	dims := append(append([]string{}, q.Rows...), q.Cols...)
+
+	rowTotals := newTotals()
+	colTotals := newTotals()
+	for _, g := range groups {
+		id := groupID(g["_id"])
+		key := make([]string, len(dims))
+		for i := range key {
+			key[i] = dimValue(id[dimKey(i)])
+			if label, ok := g[labelKey(i)].(string); ok {
+				if result.Labels == nil {
+					result.Labels = make(map[string]map[string]string)
+				}
+				if result.Labels[dims[i]] == nil {
+					result.Labels[dims[i]] = make(map[string]string)
+				}
+				result.Labels[dims[i]][key[i]] = label
+			}
+		}
+
+		values := make(map[string]float64, len(pivotMeasures))
+		for name, measure := range pivotMeasures {
+			if measure.sum != nil {
+				values[name] = toFloat(g[name])
+			}
+		}
+
+		row, col := key[:len(q.Rows)], key[len(q.Rows):]
+		result.Cells = append(result.Cells, PivotCell{Row: row, Col: col, Values: values})
+		rowTotals.add(row, values)
+		colTotals.add(col, values)
+		addValues(result.GrandTotal, values)
+	}
+
+	result.RowKeys, result.RowTotals = rowTotals.sorted(len(q.Rows))
+	result.ColKeys, result.ColTotals = colTotals.sorted(len(q.Cols))
+
+	// Derived measures are computed from the summed ones once every total is known
+	for i := range result.Cells {
+		result.Cells[i].Values = selectMeasures(result.Cells[i].Values, q.Measures)
+	}
+	for i := range result.RowTotals {
+		result.RowTotals[i].Values = selectMeasures(result.RowTotals[i].Values, q.Measures)
+	}
+	for i := range result.ColTotals {
+		result.ColTotals[i].Values = selectMeasures(result.ColTotals[i].Values, q.Measures)
+	}
+	result.GrandTotal = selectMeasures(result.GrandTotal, q.Measures)
+
+	sort.Slice(result.Cells, func(i, j int) bool {
+		if c := compareKeys(result.Cells[i].Row, result.Cells[j].Row); c != 0 {
+			return c < 0
+		}
+		return compareKeys(result.Cells[i].Col, result.Cells[j].Col) < 0
+	})
+
+	return result, nil
+}
+
+// pivotTotals accumulates measures per key prefix
+type pivotTotals map[string]*PivotTotal
+
+func newTotals() pivotTotals {
+	return make(pivotTotals)
+}
+
+// add adds values to the totals of every non-empty prefix of key
+func (t pivotTotals) add(key []string, values map[string]float64) {
+	for level := 1; level <= len(key); level++ {
+		prefix := key[:level]
+		id := strings.Join(prefix, "\x00")
+		total, ok := t[id]
+		if !ok {
+			total = &PivotTotal{Key: prefix, Level: level, Values: make(map[string]float64)}
+			t[id] = total
+		}
+		addValues(total.Values, values)
+	}
+}
+
+// sorted returns the full keys and all totals in key order, each subtotal
+// directly following the keys it summarises
+func (t pivotTotals) sorted(depth int) ([][]string, []PivotTotal) {
+	keys := [][]string{}
+	totals := make([]PivotTotal, 0, len(t))
+	for _, total := range t {
+		totals = append(totals, *total)
+		if total.Level == depth {
+			keys = append(keys, total.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return compareKeys(keys[i], keys[j]) < 0 })
+	sort.Slice(totals, func(i, j int) bool {
+		a, b := totals[i], totals[j]
+		n := min(len(a.Key), len(b.Key))
+		if c := compareKeys(a.Key[:n], b.Key[:n]); c != 0 {
+			return c < 0
+		}
+		return a.Level > b.Level
+	})
+	return keys, totals
+}
+
+// selectMeasures returns the requested measures of summed values, deriving
+// computed measures as needed
+func selectMeasures(sums map[string]float64, measures []string) map[string]float64 {
+	values := make(map[string]float64, len(measures))
+	for _, name := range measures {
+		if derive := pivotMeasures[name].derive; derive != nil {
+			values[name] = derive(sums)
+		} else {
+			values[name] = sums[name]
+		}
+	}
+	return values
+}
+
+func addValues(dst, src map[string]float64) {
+	for measure, value := range src {
+		dst[measure] += value
+	}
+}
+
+func compareKeys(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// groupID returns the compound _id of a $group result as a map
+func groupID(v interface{}) bson.M {
+	switch id := v.(type) {
+	case bson.M:
+		return id
+	case bson.D:
+		return id.Map()
+	default:
+		return bson.M{}
+	}
+}
+
+func dimKey(i int) string   { return fmt.Sprintf("d%d", i) }
+func labelKey(i int) string { return fmt.Sprintf("label%d", i) }
+
+// dimValue formats a group key value; time buckets become YYYY-MM-DD
+func dimValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case primitive.DateTime:
+		return value.Time().UTC().Format("2006-01-02")
+	case time.Time:
+		return value.UTC().Format("2006-01-02")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// toFloat converts a numeric BSON value to float64
+func toFloat(v interface{}) float64 {
+	switch value := v.(type) {
+	case float64:
+		return value
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case int:
+		return float64(value)
+	default:
+		return 0
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pivotGroup is a $group result of a pivot with the revenue and orders measures
+func pivotGroup(id bson.M, revenue float64, orders int32) bson.M {
+	return bson.M{"_id": id, "revenue": revenue, "orders": orders}
+}
+
+func month(m time.Month) primitive.DateTime {
+	return primitive.NewDateTimeFromTime(time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestBuildPivotTotals(t *testing.T) {
+	q := PivotQuery{
+		Rows:     []string{"region", "category"},
+		Cols:     []string{"month"},
+		Measures: []string{"revenue", "orders", "average_order_value"},
+	}
+	// Out of order, as $group returns them
+	groups := []bson.M{
+		pivotGroup(bson.M{"d0": "US", "d1": "Tools", "d2": month(time.February)}, 400, 4),
+		pivotGroup(bson.M{"d0": "Europe", "d1": "Tools", "d2": month(time.January)}, 100, 1),
+		pivotGroup(bson.M{"d0": "Europe", "d1": "Books", "d2": month(time.February)}, 60, 3),
+		pivotGroup(bson.M{"d0": "Europe", "d1": "Books", "d2": month(time.January)}, 40, 1),
+	}
+
+	result, err := buildPivot(q, groups)
+	if err != nil {
+		t.Fatalf("buildPivot failed: %v", err)
+	}
+
+	type cell struct{ row, col []string }
+	var cells []cell
+	for _, c := range result.Cells {
+		cells = append(cells, cell{c.Row, c.Col})
+	}
+	wantCells := []cell{
+		{[]string{"Europe", "Books"}, []string{"2024-01-01"}},
+		{[]string{"Europe", "Books"}, []string{"2024-02-01"}},
+		{[]string{"Europe", "Tools"}, []string{"2024-01-01"}},
+		{[]string{"US", "Tools"}, []string{"2024-02-01"}},
+	}
+	if !reflect.DeepEqual(cells, wantCells) {
+		t.Errorf("cells = %v, want %v", cells, wantCells)
+	}
+
+	wantRowKeys := [][]string{{"Europe", "Books"}, {"Europe", "Tools"}, {"US", "Tools"}}
+	if !reflect.DeepEqual(result.RowKeys, wantRowKeys) {
+		t.Errorf("row keys = %v, want %v", result.RowKeys, wantRowKeys)
+	}
+	wantColKeys := [][]string{{"2024-01-01"}, {"2024-02-01"}}
+	if !reflect.DeepEqual(result.ColKeys, wantColKeys) {
+		t.Errorf("col keys = %v, want %v", result.ColKeys, wantColKeys)
+	}
+
+	// Subtotals follow the rows they summarise
+	wantRowTotals := []PivotTotal{
+		{Key: []string{"Europe", "Books"}, Level: 2, Values: map[string]float64{"revenue": 100, "orders": 4, "average_order_value": 25}},
+		{Key: []string{"Europe", "Tools"}, Level: 2, Values: map[string]float64{"revenue": 100, "orders": 1, "average_order_value": 100}},
+		{Key: []string{"Europe"}, Level: 1, Values: map[string]float64{"revenue": 200, "orders": 5, "average_order_value": 40}},
+		{Key: []string{"US", "Tools"}, Level: 2, Values: map[string]float64{"revenue": 400, "orders": 4, "average_order_value": 100}},
+		{Key: []string{"US"}, Level: 1, Values: map[string]float64{"revenue": 400, "orders": 4, "average_order_value": 100}},
+	}
+	if !reflect.DeepEqual(result.RowTotals, wantRowTotals) {
+		t.Errorf("row totals = %+v, want %+v", result.RowTotals, wantRowTotals)
+	}
+
+	wantColTotals := []PivotTotal{
+		{Key: []string{"2024-01-01"}, Level: 1, Values: map[string]float64{"revenue": 140, "orders": 2, "average_order_value": 70}},
+		{Key: []string{"2024-02-01"}, Level: 1, Values: map[string]float64{"revenue": 460, "orders": 7, "average_order_value": 460.0 / 7}},
+	}
+	if !reflect.DeepEqual(result.ColTotals, wantColTotals) {
+		t.Errorf("col totals = %+v, want %+v", result.ColTotals, wantColTotals)
+	}
+
+	wantGrand := map[string]float64{"revenue": 600, "orders": 9, "average_order_value": 600.0 / 9}
+	if !reflect.DeepEqual(result.GrandTotal, wantGrand) {
+		t.Errorf("grand total = %v, want %v", result.GrandTotal, wantGrand)
+	}
+}
+
+func TestBuildPivotWithoutDimensions(t *testing.T) {
+	result, err := buildPivot(PivotQuery{Measures: []string{"revenue"}}, []bson.M{pivotGroup(nil, 250, 5)})
+	if err != nil {
+		t.Fatalf("buildPivot failed: %v", err)
+	}
+	if len(result.Cells) != 1 || len(result.RowKeys) != 0 || len(result.ColKeys) != 0 {
+		t.Errorf("result = %+v, want a single cell without keys", result)
+	}
+	if result.GrandTotal["revenue"] != 250 {
+		t.Errorf("grand total = %v, want revenue 250", result.GrandTotal)
+	}
+}
+
+func TestBuildPivotLabels(t *testing.T) {
+	q := PivotQuery{Rows: []string{"product"}, Measures: []string{"revenue"}}
+	groups := []bson.M{
+		{"_id": bson.M{"d0": "P1"}, "label0": "Widget", "revenue": 10.0},
+		{"_id": bson.M{"d0": "P2"}, "label0": "Gadget", "revenue": 20.0},
+	}
+
+	result, err := buildPivot(q, groups)
+	if err != nil {
+		t.Fatalf("buildPivot failed: %v", err)
+	}
+	want := map[string]map[string]string{"product": {"P1": "Widget", "P2": "Gadget"}}
+	if !reflect.DeepEqual(result.Labels, want) {
+		t.Errorf("labels = %v, want %v", result.Labels, want)
+	}
+}
+
+func TestBuildPivotTooLarge(t *testing.T) {
+	q := PivotQuery{Rows: []string{"customer"}, Measures: []string{"revenue"}}
+
+	groups := make([]bson.M, maxPivotGroups)
+	for i := range groups {
+		groups[i] = pivotGroup(bson.M{"d0": dimKey(i)}, 1, 1)
+	}
+	if _, err := buildPivot(q, groups); err != nil {
+		t.Fatalf("buildPivot with %d groups failed: %v", len(groups), err)
+	}
+
+	groups = append(groups, pivotGroup(bson.M{"d0": "one more"}, 1, 1))
+	if _, err := buildPivot(q, groups); !errors.Is(err, ErrPivotTooLarge) {
+		t.Errorf("buildPivot with %d groups = %v, want ErrPivotTooLarge", len(groups), err)
+	}
+
+	// Grouped revenue pages its groups instead
+	q.unbounded = true
+	if result, err := buildPivot(q, groups); err != nil || len(result.RowTotals) != len(groups) {
+		t.Errorf("unbounded buildPivot with %d groups failed: %v", len(groups), err)
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Refresh statuses
+const (
+	RefreshQueued    = "queued"
+	RefreshRunning   = "running"
+	RefreshSuccess   = "success"
+	RefreshFailed    = "failed"
+	RefreshCancelled = "cancelled"
+)
+
+// ErrRefreshNotFound is returned when no refresh log matches the requested ID
+var ErrRefreshNotFound = errors.New("refresh not found")
+
+// CreateRefreshLog records a new refresh with status running or queued
+func (r *MongoRepository) CreateRefreshLog(ctx context.Context, id primitive.ObjectID, trigger, status string) error {
+	now := time.Now()
+	refreshLog := RefreshLog{
+		ID:        id,
+		Trigger:   trigger,
+		StartTime: now,
+		Status:    status,
+		Progress:  RefreshProgress{UpdatedAt: now},
+	}
+
+	_, err := r.GetCollection("refresh_logs").InsertOne(ctx, refreshLog)
+	return err
+}
+
+// MarkRefreshRunning moves a queued refresh to running and resets its start time
+func (r *MongoRepository) MarkRefreshRunning(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.GetCollection("refresh_logs").UpdateOne(ctx,
+		bson.M{"_id": id, "status": RefreshQueued},
+		bson.M{"$set": bson.M{"status": RefreshRunning, "start_time": time.Now()}},
+	)
+	return err
+}
+
+// RequestRefreshCancel flags a queued or running refresh for cancellation by whichever
+// instance owns it. It returns false when the refresh is not active.
+func (r *MongoRepository) RequestRefreshCancel(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.GetCollection("refresh_logs").UpdateOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": bson.A{RefreshQueued, RefreshRunning}}},
+		bson.M{"$set": bson.M{"cancel_requested": true}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// UpdateRefreshProgress stores the latest progress of a running refresh
+func (r *MongoRepository) UpdateRefreshProgress(ctx context.Context, id primitive.ObjectID, progress RefreshProgress) error {
+	_, err := r.GetCollection("refresh_logs").UpdateOne(ctx,
+		bson.M{"_id": id, "status": RefreshRunning},
+		bson.M{"$set": bson.M{"progress": progress}},
+	)
+	return err
+}
+
+// FinishRefreshLog records the outcome of a refresh
+func (r *MongoRepository) FinishRefreshLog(ctx context.Context, refreshLog RefreshLog) error {
+	_, err := r.GetCollection("refresh_logs").UpdateOne(ctx,
+		bson.M{"_id": refreshLog.ID},
+		bson.M{"$set": bson.M{
+			"end_time":      refreshLog.EndTime,
+			"status":        refreshLog.Status,
+			"rows_loaded":   refreshLog.RowsLoaded,
+			"rows_accepted": refreshLog.RowsAccepted,
+			"rows_rejected": refreshLog.RowsRejected,
+			"error_msg":     refreshLog.ErrorMsg,
+			"progress":      refreshLog.Progress,
+		}},
+	)
+	return err
+}
+
+// GetRefreshLog retrieves a single refresh log by ID
+func (r *MongoRepository) GetRefreshLog(ctx context.Context, id primitive.ObjectID) (*RefreshLog, error) {
+	var refreshLog RefreshLog
+	err := r.GetCollection("refresh_logs").FindOne(ctx, bson.M{"_id": id}).Decode(&refreshLog)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRefreshNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &refreshLog, nil
+}
+
+// GetRefreshLogs retrieves the latest refresh logs
+func (r *MongoRepository) GetRefreshLogs(ctx context.Context, limit int) ([]RefreshLog, error) {
+	opts := options.Find().SetSort(bson.M{"start_time": -1}).SetLimit(int64(limit))
+
+	cursor, err := r.GetCollection("refresh_logs").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var logs []RefreshLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// GetRejectedRows retrieves the rows rejected during a refresh, ordered by line number
+func (r *MongoRepository) GetRejectedRows(ctx context.Context, refreshID primitive.ObjectID, limit, offset int) ([]RejectedRow, int64, error) {
+	coll := r.GetCollection("rejected_rows")
+	filter := bson.M{"refresh_id": refreshID}
+
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "line_number", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rows := []RejectedRow{}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, 0, err
+	}
+
+	return rows, total, nil
+}
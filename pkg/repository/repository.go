@@ -2,7 +2,10 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"sales_analytics/config"
 
@@ -16,6 +19,7 @@ type MongoRepository struct {
 	client *mongo.Client
 	db     *mongo.Database
 	config *config.Config
+	usage  *apiKeyUsage // API key use not yet written
 }
 
 // NewMongoRepository creates a new MongoDB repository
@@ -36,12 +40,20 @@ func NewMongoRepository(ctx context.Context, cfg *config.Config) (*MongoReposito
 		client: client,
 		db:     db,
 		config: cfg,
+		usage:  newAPIKeyUsage(),
 	}
+	// Upgrade documents written before product and customer history was kept
+	if err := repo.migrateHistory(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate history: %w", err)
+	}
+
 	// Create indexes
 	if err := repo.createIndexes(ctx); err != nil {
 		return nil, fmt.Errorf("failed to create indexes: %w", err)
 	}
 
+	go repo.writeAPIKeyUsage()
+
 	return repo, nil
 }
 
@@ -49,7 +61,7 @@ func NewMongoRepository(ctx context.Context, cfg *config.Config) (*MongoReposito
 func (r *MongoRepository) createIndexes(ctx context.Context) error {
 	// Customer indexes
 	customerIndexes := []mongo.IndexModel{
-		{Keys: bson.D{{Key: "customer_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "valid_from", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "email", Value: 1}}},
 	}
 	if _, err := r.db.Collection("customers").Indexes().CreateMany(ctx, customerIndexes); err != nil {
@@ -58,7 +70,7 @@ func (r *MongoRepository) createIndexes(ctx context.Context) error {
 
 	// Product indexes
 	productIndexes := []mongo.IndexModel{
-		{Keys: bson.D{{Key: "product_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "valid_from", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "category", Value: 1}}},
 	}
 	if _, err := r.db.Collection("products").Indexes().CreateMany(ctx, productIndexes); err != nil {
@@ -72,16 +84,101 @@ func (r *MongoRepository) createIndexes(ctx context.Context) error {
 		{Keys: bson.D{{Key: "product_id", Value: 1}}},
 		{Keys: bson.D{{Key: "date_of_sale", Value: 1}}},
 		{Keys: bson.D{{Key: "region", Value: 1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}}},
+		{Keys: bson.D{{Key: "basket_id", Value: 1}}, Options: options.Index().SetSparse(true)},
 	}
 	if _, err := r.db.Collection("orders").Indexes().CreateMany(ctx, orderIndexes); err != nil {
 		return err
 	}
 
+	// Rejected row indexes
+	rejectedIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "refresh_id", Value: 1}, {Key: "line_number", Value: 1}}},
+	}
+	if _, err := r.db.Collection("rejected_rows").Indexes().CreateMany(ctx, rejectedIndexes); err != nil {
+		return err
+	}
+
+	// Daily rollup indexes, kept when the rollup is replaced
+	rollupIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "date_of_sale", Value: 1}}},
+		{Keys: bson.D{{Key: "product_id", Value: 1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}}},
+		{Keys: bson.D{{Key: "region", Value: 1}}},
+	}
+	if _, err := r.db.Collection(rollupCollection).Indexes().CreateMany(ctx, rollupIndexes); err != nil {
+		return err
+	}
+
+	// Anomaly indexes
+	anomalyIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "dimension", Value: 1}, {Key: "value", Value: 1}, {Key: "date", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "date", Value: -1}, {Key: "severity", Value: -1}}},
+		{Keys: bson.D{{Key: "refresh_id", Value: 1}}},
+	}
+	if _, err := r.db.Collection("anomalies").Indexes().CreateMany(ctx, anomalyIndexes); err != nil {
+		return err
+	}
+
+	// API key indexes
+	apiKeyIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+	if _, err := r.db.Collection("api_keys").Indexes().CreateMany(ctx, apiKeyIndexes); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrateHistory turns documents without a validity range into open-ended versions
+// and drops the unique indexes that allowed a single version per product or customer
+func (r *MongoRepository) migrateHistory(ctx context.Context) error {
+	for _, entity := range []versionedEntity{productHistory, customerHistory} {
+		coll := r.db.Collection(entity.collection)
+
+		if err := dropIndexIfExists(ctx, coll, entity.key+"_1"); err != nil {
+			return err
+		}
+
+		_, err := coll.UpdateMany(ctx,
+			bson.M{"valid_from": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"valid_from": time.Unix(0, 0).UTC(), "valid_to": nil, "is_current": true}},
+		)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Disconnect closes the MongoDB connection
+// dropIndexIfExists drops the named index when the collection has it
+func dropIndexIfExists(ctx context.Context, coll *mongo.Collection, name string) error {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 26 { // NamespaceNotFound: collection not created yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Name == name {
+			_, err := coll.Indexes().DropOne(ctx, name)
+			return err
+		}
+	}
+	return nil
+}
+
+// Disconnect writes the buffered API key use and closes the MongoDB connection
 func (r *MongoRepository) Disconnect(ctx context.Context) error {
+	// Write the API key use buffered since the last flush
+	close(r.usage.stop)
+	<-r.usage.done
+	if err := r.flushAPIKeyUsage(ctx); err != nil {
+		log.Printf("Failed to record API key usage: %v", err)
+	}
 	return r.client.Disconnect(ctx)
 }
 
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rfmQuantiles is the number of score buckets for recency, frequency and monetary value
+const rfmQuantiles = 5
+
+// RFM segment names
+const (
+	SegmentChampions          = "Champions"
+	SegmentLoyal              = "Loyal Customers"
+	SegmentPotentialLoyalists = "Potential Loyalists"
+	SegmentNew                = "New Customers"
+	SegmentNeedAttention      = "Need Attention"
+	SegmentAboutToSleep       = "About to Sleep"
+	SegmentCannotLose         = "Cannot Lose Them"
+	SegmentAtRisk             = "At Risk"
+	SegmentHibernating        = "Hibernating"
+	SegmentLost               = "Lost"
+)
+
+// RFMSegments lists every segment in reporting order
+var RFMSegments = []string{
+	SegmentChampions,
+	SegmentLoyal,
+	SegmentPotentialLoyalists,
+	SegmentNew,
+	SegmentNeedAttention,
+	SegmentAboutToSleep,
+	SegmentCannotLose,
+	SegmentAtRisk,
+	SegmentHibernating,
+	SegmentLost,
+}
+
+// CustomerRFM  recency, frequency and monetary scores of a customer
+type CustomerRFM struct {
+	CustomerID     string    `json:"customer_id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	LastPurchase   time.Time `json:"last_purchase"`
+	RecencyDays    int       `json:"recency_days"`
+	Frequency      int       `json:"frequency"`
+	Monetary       float64   `json:"monetary"`
+	RecencyScore   int       `json:"recency_score"`
+	FrequencyScore int       `json:"frequency_score"`
+	MonetaryScore  int       `json:"monetary_score"`
+	RFMScore       string    `json:"rfm_score"` // R, F and M scores concatenated, e.g. "545"
+	Segment        string    `json:"segment"`
+}
+
+// RFMSegment  customers assigned to one RFM segment
+type RFMSegment struct {
+	Segment   string        `json:"segment"`
+	Count     int           `json:"count"`
+	Share     float64       `json:"share"` // fraction of all scored customers
+	Customers []CustomerRFM `json:"customers"`
+}
+
+// RFMAnalysis  result of an RFM segmentation
+type RFMAnalysis struct {
+	ReferenceDate  time.Time      `json:"reference_date"`
+	TotalCustomers int            `json:"total_customers"`
+	Counts         map[string]int `json:"counts"`
+	Segments       []RFMSegment   `json:"segments"`
+}
+
+// CalculateRFMSegments scores every customer with orders matching filter up to the end of
+// referenceDate in quintiles of recency, frequency and monetary value and assigns a named segment.
+// At most customerLimit customers are listed per segment; counts always cover everyone.
+func (r *MongoRepository) CalculateRFMSegments(ctx context.Context, referenceDate time.Time, customerLimit int, filter bson.M) (*RFMAnalysis, error) {
+	referenceDate = time.Date(referenceDate.Year(), referenceDate.Month(), referenceDate.Day(), 0, 0, 0, 0, time.UTC)
+	cutoff := referenceDate.AddDate(0, 0, 1)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: withFilter(bson.M{
+			"date_of_sale": bson.M{"$lt": cutoff},
+		}, filter)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$customer_id",
+			"last_purchase": bson.M{"$max": "$date_of_sale"},
+			"order_count":   bson.M{"$sum": 1},
+			"total_revenue": bson.M{"$sum": "$line_revenue"},
+		}}},
+	}
+	pipeline = append(pipeline, currentCustomerLookup()...)
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		CustomerID   string    `bson:"_id"`
+		Name         string    `bson:"name"`
+		Email        string    `bson:"email"`
+		LastPurchase time.Time `bson:"last_purchase"`
+		OrderCount   int       `bson:"order_count"`
+		TotalRevenue float64   `bson:"total_revenue"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to read customer activity: %w", err)
+	}
+
+	customers := make([]CustomerRFM, len(rows))
+	for i, row := range rows {
+		lastDay := row.LastPurchase.UTC().Truncate(24 * time.Hour)
+		customers[i] = CustomerRFM{
+			CustomerID:   row.CustomerID,
+			Name:         row.Name,
+			Email:        row.Email,
+			LastPurchase: row.LastPurchase,
+			RecencyDays:  int(referenceDate.Sub(lastDay).Hours() / 24),
+			Frequency:    row.OrderCount,
+			Monetary:     row.TotalRevenue,
+		}
+	}
+
+	// Fewer days since the last purchase is better, so recency is scored on its negation
+	recency := quintileScores(customers, func(c CustomerRFM) float64 { return -float64(c.RecencyDays) })
+	frequency := quintileScores(customers, func(c CustomerRFM) float64 { return float64(c.Frequency) })
+	monetary := quintileScores(customers, func(c CustomerRFM) float64 { return c.Monetary })
+	for i := range customers {
+		c := &customers[i]
+		c.RecencyScore, c.FrequencyScore, c.MonetaryScore = recency[i], frequency[i], monetary[i]
+		c.RFMScore = fmt.Sprintf("%d%d%d", c.RecencyScore, c.FrequencyScore, c.MonetaryScore)
+		c.Segment = rfmSegment(c.RecencyScore, c.FrequencyScore, c.MonetaryScore)
+	}
+
+	// Best customers first within each segment
+	sort.Slice(customers, func(i, j int) bool {
+		if customers[i].Monetary != customers[j].Monetary {
+			return customers[i].Monetary > customers[j].Monetary
+		}
+		return customers[i].CustomerID < customers[j].CustomerID
+	})
+
+	bySegment := make(map[string]*RFMSegment, len(RFMSegments))
+	analysis := &RFMAnalysis{
+		ReferenceDate:  referenceDate,
+		TotalCustomers: len(customers),
+		Counts:         make(map[string]int, len(RFMSegments)),
+		Segments:       make([]RFMSegment, len(RFMSegments)),
+	}
+	for i, name := range RFMSegments {
+		analysis.Segments[i] = RFMSegment{Segment: name, Customers: []CustomerRFM{}}
+		analysis.Counts[name] = 0
+		bySegment[name] = &analysis.Segments[i]
+	}
+	for _, c := range customers {
+		segment := bySegment[c.Segment]
+		segment.Count++
+		if len(segment.Customers) < customerLimit {
+			segment.Customers = append(segment.Customers, c)
+		}
+	}
+	for i := range analysis.Segments {
+		segment := &analysis.Segments[i]
+		analysis.Counts[segment.Segment] = segment.Count
+		if len(customers) > 0 {
+			segment.Share = float64(segment.Count) / float64(len(customers))
+		}
+	}
+
+	return analysis, nil
+}
+
+// quintileScores ranks customers by value and scores them 1 (lowest) to 5 (highest).
+// Customers with equal values always receive the same score.
+func quintileScores(customers []CustomerRFM, value func(CustomerRFM) float64) []int {
+	order := make([]int, len(customers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return value(customers[order[a]]) < value(customers[order[b]])
+	})
+
+	scores := make([]int, len(customers))
+	rank := 0
+	for pos, idx := range order {
+		if pos == 0 || value(customers[idx]) != value(customers[order[pos-1]]) {
+			rank = pos
+		}
+		scores[idx] = rank*rfmQuantiles/len(customers) + 1
+	}
+	return scores
+}
+
+// rfmSegment maps recency, frequency and monetary scores to a segment name
+func rfmSegment(recency, frequency, monetary int) string {
+	switch {
+	case recency >= 4 && frequency >= 4:
+		return SegmentChampions
+	case recency >= 4 && frequency >= 2:
+		return SegmentPotentialLoyalists
+	case recency >= 4:
+		return SegmentNew
+	case recency == 3 && frequency >= 4:
+		return SegmentLoyal
+	case recency == 3 && frequency >= 2:
+		return SegmentNeedAttention
+	case recency == 3:
+		return SegmentAboutToSleep
+	case frequency >= 4 && monetary >= 4:
+		return SegmentCannotLose
+	case frequency >= 3:
+		return SegmentAtRisk
+	case recency == 2:
+		return SegmentHibernating
+	default:
+		return SegmentLost
+	}
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rollupCollection holds one document per day, product, category, region and
+// payment method with the summed pivot measures of the matching orders. Its
+// date_of_sale is the start of the day, so the order match and time bucket
+// expressions apply to it unchanged.
+const rollupCollection = "daily_sales_rollup"
+
+// rollupFields are the order fields kept as keys of the rollup
+var rollupFields = []string{"product_id", "category", "region", "payment_method"}
+
+// RollupStatus  state of the daily sales rollup
+type RollupStatus struct {
+	Ready     bool               `bson:"ready" json:"ready"` // up to date with orders
+	RefreshID primitive.ObjectID `bson:"refresh_id,omitempty" json:"refresh_id,omitempty"`
+	Rows      int64              `bson:"rows" json:"rows"`
+	BuiltAt   time.Time          `bson:"built_at,omitempty" json:"built_at,omitempty"`
+	StaleAt   *time.Time         `bson:"stale_at,omitempty" json:"stale_at,omitempty"` // when orders started changing
+}
+
+// RebuildDailyRollup recomputes the daily sales rollup from every order. The new
+// rollup replaces the old one atomically, so readers never see a partial rollup.
+func (r *MongoRepository) RebuildDailyRollup(ctx context.Context, refreshID primitive.ObjectID) (*RollupStatus, error) {
+	id := bson.M{"date_of_sale": GranularityDay.bucketExpr()}
+	project := bson.M{"_id": 0, "date_of_sale": "$_id.date_of_sale", "product_name": 1}
+	for _, field := range rollupFields {
+		id[field] = "$" + field
+		project[field] = "$_id." + field
+	}
+
+	group := bson.M{
+		"_id":          id,
+		"product_name": bson.M{"$max": "$product_name"},
+	}
+	for name, measure := range pivotMeasures {
+		if measure.sum != nil {
+			group[name] = bson.M{"$sum": measure.sum}
+			project[name] = 1
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: group}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$out", Value: rollupCollection}},
+	}
+
+	cursor, err := r.GetCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	cursor.Close(ctx)
+
+	rows, err := r.GetCollection(rollupCollection).EstimatedDocumentCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &RollupStatus{Ready: true, RefreshID: refreshID, Rows: rows, BuiltAt: time.Now()}
+	_, err = r.GetCollection("rollup_status").UpdateOne(ctx,
+		bson.M{"_id": rollupCollection},
+		bson.M{
+			"$set":   status,
+			"$unset": bson.M{"stale_at": ""},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// InvalidateDailyRollup marks the rollup as out of date, sending analytics back to
+// orders until the next rebuild
+func (r *MongoRepository) InvalidateDailyRollup(ctx context.Context) error {
+	_, err := r.GetCollection("rollup_status").UpdateOne(ctx,
+		bson.M{"_id": rollupCollection},
+		bson.M{"$set": bson.M{"ready": false, "stale_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetRollupStatus retrieves the state of the daily sales rollup. A rollup that was
+// never built is reported as not ready.
+func (r *MongoRepository) GetRollupStatus(ctx context.Context) (*RollupStatus, error) {
+	var status RollupStatus
+	err := r.GetCollection("rollup_status").FindOne(ctx, bson.M{"_id": rollupCollection}).Decode(&status)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &RollupStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// salesSource  collection sales are aggregated from
+type salesSource struct {
+	collection string
+	rollup     bool
+}
+
+// measure returns the expression summed into a pivot measure
+func (s salesSource) measure(name string) interface{} {
+	if s.rollup {
+		return "$" + name
+	}
+	return pivotMeasures[name].sum
+}
+
+// salesSourceFor reads from the rollup when it is up to date and the query fits its
+// grain: whole days, grouped and filtered only by fields the rollup keeps. Zero
+// dates skip the range check. Any doubt falls back to orders.
+func (r *MongoRepository) salesSourceFor(ctx context.Context, startDate, endDate time.Time, filter bson.M, fields ...string) salesSource {
+	orders := salesSource{collection: "orders"}
+
+	if !startDate.IsZero() && !GranularityDay.BucketStart(startDate).Equal(startDate) {
+		return orders
+	}
+	if !endDate.IsZero() && GranularityDay.Next(GranularityDay.BucketStart(endDate)).Sub(endDate) > time.Second {
+		return orders
+	}
+	for field := range filter {
+		if !slices.Contains(rollupFields, field) {
+			return orders
+		}
+	}
+	for _, field := range fields {
+		if !slices.Contains(rollupFields, strings.TrimPrefix(field, "$")) {
+			return orders
+		}
+	}
+
+	status, err := r.GetRollupStatus(ctx)
+	if err != nil || !status.Ready {
+		return orders
+	}
+	return salesSource{collection: rollupCollection, rollup: true}
+}
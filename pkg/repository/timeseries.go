@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Granularity  size of the time buckets of a revenue time series
+type Granularity string
+
+// Supported time series granularities
+const (
+	GranularityDay     Granularity = "day"
+	GranularityWeek    Granularity = "week"
+	GranularityMonth   Granularity = "month"
+	GranularityQuarter Granularity = "quarter"
+	GranularityYear    Granularity = "year"
+)
+
+// ParseGranularity validates a granularity name
+func ParseGranularity(name string) (Granularity, error) {
+	switch g := Granularity(name); g {
+	case GranularityDay, GranularityWeek, GranularityMonth, GranularityQuarter, GranularityYear:
+		return g, nil
+	}
+	return "", fmt.Errorf("invalid granularity %q, use day, week, month, quarter or year", name)
+}
+
+// BucketStart truncates t to the start of its bucket. Weeks start on Monday (ISO 8601).
+func (g Granularity) BucketStart(t time.Time) time.Time {
+	t = t.UTC()
+	switch g {
+	case GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case GranularityQuarter:
+		month := time.Month((int(t.Month())-1)/3*3 + 1)
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	case GranularityYear:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the start of the bucket following the one starting at t
+func (g Granularity) Next(t time.Time) time.Time {
+	switch g {
+	case GranularityWeek:
+		return t.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return t.AddDate(0, 1, 0)
+	case GranularityQuarter:
+		return t.AddDate(0, 3, 0)
+	case GranularityYear:
+		return t.AddDate(1, 0, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// maxSeriesPoints bounds the number of points, buckets times series, a time series may hold
+const maxSeriesPoints = 100000
+
+// ErrSeriesTooLarge is returned when a time series would hold more points than maxSeriesPoints
+var ErrSeriesTooLarge = fmt.Errorf("time series has more than %d points, use a coarser granularity, a shorter date range or narrower filters", maxSeriesPoints)
+
+// CheckRange returns ErrSeriesTooLarge when [startDate, endDate] spans more buckets
+// than a time series may hold
+func (g Granularity) CheckRange(startDate, endDate time.Time) error {
+	n := 0
+	for b := g.BucketStart(startDate); !b.After(endDate); b = g.Next(b) {
+		if n++; n > maxSeriesPoints {
+			return ErrSeriesTooLarge
+		}
+	}
+	return nil
+}
+
+// Buckets returns the start of every bucket overlapping [startDate, endDate]. The
+// count is not bounded; call CheckRange first for ranges taken from a request.
+func (g Granularity) Buckets(startDate, endDate time.Time) []time.Time {
+	var buckets []time.Time
+	for b := g.BucketStart(startDate); !b.After(endDate); b = g.Next(b) {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// bucketExpr computes the bucket start of date_of_sale inside an aggregation
+func (g Granularity) bucketExpr() bson.M {
+	date := "$date_of_sale"
+	switch g {
+	case GranularityWeek:
+		return bson.M{"$dateFromParts": bson.M{
+			"isoWeekYear":  bson.M{"$isoWeekYear": date},
+			"isoWeek":      bson.M{"$isoWeek": date},
+			"isoDayOfWeek": 1,
+		}}
+	case GranularityMonth:
+		return bson.M{"$dateFromParts": bson.M{
+			"year":  bson.M{"$year": date},
+			"month": bson.M{"$month": date},
+		}}
+	case GranularityQuarter:
+		return bson.M{"$dateFromParts": bson.M{
+			"year": bson.M{"$year": date},
+			"month": bson.M{"$add": bson.A{
+				bson.M{"$multiply": bson.A{
+					bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{bson.M{"$month": date}, 1}}, 3}}},
+					3,
+				}},
+				1,
+			}},
+		}}
+	case GranularityYear:
+		return bson.M{"$dateFromParts": bson.M{
+			"year": bson.M{"$year": date},
+		}}
+	default:
+		return bson.M{"$dateFromParts": bson.M{
+			"year":  bson.M{"$year": date},
+			"month": bson.M{"$month": date},
+			"day":   bson.M{"$dayOfMonth": date},
+		}}
+	}
+}
+
+// seriesGroupFields maps the group_by values of a time series to order fields
+var seriesGroupFields = map[string]string{
+	"product":  "$product_id",
+	"category": "$category",
+	"region":   "$region",
+}
+
+// RevenuePoint  revenue of one time bucket
+type RevenuePoint struct {
+	PeriodStart  time.Time `json:"period_start"`
+	TotalRevenue float64   `json:"total_revenue"`
+}
+
+// RevenueSeries  revenue time series of one group
+type RevenueSeries struct {
+	Group        string         `json:"group,omitempty"`
+	Label        string         `json:"label,omitempty"` // product name when grouped by product
+	TotalRevenue float64        `json:"total_revenue"`
+	Points       []RevenuePoint `json:"points"`
+}
+
+// CalculateRevenueTimeSeries revenue per time bucket, optionally split into one series
+// per product, category or region. Buckets without sales are reported as zero.
+// Returns ErrSeriesTooLarge when the zero-filled series would exceed maxSeriesPoints.
+func (r *MongoRepository) CalculateRevenueTimeSeries(ctx context.Context, startDate, endDate time.Time, granularity Granularity, groupBy string, filter bson.M) ([]RevenueSeries, error) {
+	if err := granularity.CheckRange(startDate, endDate); err != nil {
+		return nil, err
+	}
+
+	var groupField interface{}
+	if groupBy != "" {
+		field, ok := seriesGroupFields[groupBy]
+		if !ok {
+			return nil, fmt.Errorf("invalid group_by %q, use product, category or region", groupBy)
+		}
+		groupField = field
+	}
+
+	var fields []string
+	if groupBy != "" {
+		fields = append(fields, seriesGroupFields[groupBy])
+	}
+	source := r.salesSourceFor(ctx, startDate, endDate, filter, fields...)
+
+	pipeline := mongo.Pipeline{
+		matchOrders(startDate, endDate, filter),
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"bucket": granularity.bucketExpr(),
+				"group":  groupField,
+			},
+			"label":         bson.M{"$first": "$product_name"},
+			"total_revenue": bson.M{"$sum": source.measure("revenue")},
+		}}},
+	}
+
+	cursor, err := r.GetCollection(source.collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID struct {
+			Bucket time.Time `bson:"bucket"`
+			Group  string    `bson:"group"`
+		} `bson:"_id"`
+		Label        string  `bson:"label"`
+		TotalRevenue float64 `bson:"total_revenue"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	// Collect the revenue of each group by bucket
+	type groupTotals struct {
+		label   string
+		total   float64
+		buckets map[time.Time]float64
+	}
+	groups := make(map[string]*groupTotals)
+	if groupBy == "" {
+		groups[""] = &groupTotals{buckets: make(map[time.Time]float64)}
+	}
+	for _, row := range rows {
+		g, ok := groups[row.ID.Group]
+		if !ok {
+			g = &groupTotals{buckets: make(map[time.Time]float64)}
+			groups[row.ID.Group] = g
+		}
+		if groupBy == "product" {
+			g.label = row.Label
+		}
+		g.total += row.TotalRevenue
+		g.buckets[row.ID.Bucket.UTC()] += row.TotalRevenue
+	}
+
+	// Zero-fill every series over the full range
+	buckets := granularity.Buckets(startDate, endDate)
+	if len(buckets)*len(groups) > maxSeriesPoints {
+		return nil, ErrSeriesTooLarge
+	}
+	series := make([]RevenueSeries, 0, len(groups))
+	for name, g := range groups {
+		s := RevenueSeries{
+			Group:        name,
+			Label:        g.label,
+			TotalRevenue: g.total,
+			Points:       make([]RevenuePoint, len(buckets)),
+		}
+		for i, b := range buckets {
+			s.Points[i] = RevenuePoint{PeriodStart: b, TotalRevenue: g.buckets[b]}
+		}
+		series = append(series, s)
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].TotalRevenue != series[j].TotalRevenue {
+			return series[i].TotalRevenue > series[j].TotalRevenue
+		}
+		return series[i].Group < series[j].Group
+	})
+
+	return series, nil
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGranularityCheckRange(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		granularity Granularity
+		start, end  time.Time
+		wantErr     bool
+	}{
+		{"one day", GranularityDay, day(2024, 1, 1), day(2024, 1, 1), false},
+		{"a century of days", GranularityDay, day(1950, 1, 1), day(2049, 12, 31), false},
+		{"three centuries of days", GranularityDay, day(1800, 1, 1), day(2099, 12, 31), true},
+		{"three centuries of months", GranularityMonth, day(1800, 1, 1), day(2099, 12, 31), false},
+		{"whole calendar of weeks", GranularityWeek, day(1, 1, 1), day(9999, 12, 31), true},
+		{"whole calendar of years", GranularityYear, day(1, 1, 1), day(9999, 12, 31), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.granularity.CheckRange(tt.start, tt.end)
+			if tt.wantErr && !errors.Is(err, ErrSeriesTooLarge) {
+				t.Errorf("CheckRange() = %v, want ErrSeriesTooLarge", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("CheckRange() = %v, want nil", err)
+			}
+		})
+	}
+}
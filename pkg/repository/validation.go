@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedRecord  entities built from a validated CSV row
+type ParsedRecord struct {
+	Customer Customer
+	Product  Product
+	Order    Order
+}
+
+// ValidateRecord checks a CSV record and converts it into typed entities.
+// It returns every reason the record was rejected; a nil slice means the record is valid.
+func ValidateRecord(record CSVRecord) (ParsedRecord, []string) {
+	var reasons []string
+	reject := func(format string, args ...interface{}) {
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+
+	// Required identifiers
+	if strings.TrimSpace(record.OrderID) == "" {
+		reject("order_id is required")
+	}
+	if strings.TrimSpace(record.ProductID) == "" {
+		reject("product_id is required")
+	}
+	if strings.TrimSpace(record.CustomerID) == "" {
+		reject("customer_id is required")
+	}
+
+	dateOfSale, err := time.Parse("2006-01-02", strings.TrimSpace(record.DateOfSale))
+	if err != nil {
+		reject("invalid date_of_sale %q, expected YYYY-MM-DD", record.DateOfSale)
+	}
+
+	quantitySold, err := strconv.Atoi(strings.TrimSpace(record.QuantitySold))
+	if err != nil {
+		reject("invalid quantity_sold %q", record.QuantitySold)
+	} else if quantitySold <= 0 {
+		reject("quantity_sold must be greater than 0, got %d", quantitySold)
+	}
+
+	// NaN and infinities parse as floats but would poison every sum they enter
+	unitPrice, err := parseFinite(record.UnitPrice)
+	if err != nil {
+		reject("invalid unit_price %q", record.UnitPrice)
+	} else if unitPrice < 0 {
+		reject("unit_price must not be negative, got %g", unitPrice)
+	}
+
+	discount, err := parseFinite(record.Discount)
+	if err != nil {
+		reject("invalid discount %q", record.Discount)
+	} else if discount < 0 || discount > 1 {
+		reject("discount must be between 0 and 1, got %g", discount)
+	}
+
+	// Shipping cost is optional and defaults to 0
+	var shippingCost float64
+	if s := strings.TrimSpace(record.ShippingCost); s != "" {
+		shippingCost, err = parseFinite(s)
+		if err != nil {
+			reject("invalid shipping_cost %q", record.ShippingCost)
+		} else if shippingCost < 0 {
+			reject("shipping_cost must not be negative, got %g", shippingCost)
+		}
+	}
+
+	email := strings.TrimSpace(record.CustomerEmail)
+	if email != "" {
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			reject("invalid customer_email %q", record.CustomerEmail)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return ParsedRecord{}, reasons
+	}
+
+	return ParsedRecord{
+		Customer: Customer{
+			CustomerID: strings.TrimSpace(record.CustomerID),
+			Name:       record.CustomerName,
+			Email:      email,
+			Address:    record.CustomerAddr,
+			ValidFrom:  dateOfSale,
+		},
+		Product: Product{
+			ProductID: strings.TrimSpace(record.ProductID),
+			Name:      record.ProductName,
+			Category:  record.Category,
+			UnitPrice: unitPrice,
+			Discount:  discount,
+			ValidFrom: dateOfSale,
+		},
+		Order: Order{
+			OrderID:       strings.TrimSpace(record.OrderID),
+			ProductID:     strings.TrimSpace(record.ProductID),
+			CustomerID:    strings.TrimSpace(record.CustomerID),
+			ProductName:   record.ProductName,
+			Category:      record.Category,
+			Region:        record.Region,
+			DateOfSale:    dateOfSale,
+			QuantitySold:  quantitySold,
+			UnitPrice:     unitPrice,
+			Discount:      discount,
+			LineRevenue:   lineRevenue(quantitySold, unitPrice, discount),
+			ShippingCost:  shippingCost,
+			PaymentMethod: record.PaymentMethod,
+			BasketID:      strings.TrimSpace(record.BasketID),
+		},
+	}, nil
+}
+
+// parseFinite parses a decimal number, rejecting NaN and infinities
+func parseFinite(value string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", value)
+	}
+	return f, nil
+}
+
+// lineRevenue is the revenue of an order line after discount
+func lineRevenue(quantity int, unitPrice, discount float64) float64 {
+	return float64(quantity) * (unitPrice - unitPrice*discount)
+}
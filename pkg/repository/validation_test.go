@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+)
+
+// validRecord returns a record that passes validation
+func validRecord() CSVRecord {
+	return CSVRecord{
+		OrderID:       "1001",
+		ProductID:     "P1",
+		CustomerID:    "C1",
+		ProductName:   "Widget",
+		Category:      "Tools",
+		Region:        "Europe",
+		DateOfSale:    "2024-01-10",
+		QuantitySold:  "2",
+		UnitPrice:     "100.00",
+		Discount:      "0.1",
+		ShippingCost:  "5.00",
+		PaymentMethod: "PayPal",
+		CustomerName:  "Ann",
+		CustomerEmail: "ann@example.com",
+		CustomerAddr:  "1 Main St",
+	}
+}
+
+func TestValidateRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*CSVRecord)
+		want   []string // rejection reasons; nil for a valid record
+	}{
+		{name: "valid", modify: func(*CSVRecord) {}},
+		{name: "no shipping cost", modify: func(r *CSVRecord) { r.ShippingCost = "" }},
+		{name: "no email", modify: func(r *CSVRecord) { r.CustomerEmail = "" }},
+		{name: "discount of 0", modify: func(r *CSVRecord) { r.Discount = "0" }},
+		{name: "discount of 1", modify: func(r *CSVRecord) { r.Discount = "1" }},
+		{
+			name:   "discount above 1",
+			modify: func(r *CSVRecord) { r.Discount = "1.5" },
+			want:   []string{"discount must be between 0 and 1, got 1.5"},
+		},
+		{
+			name:   "negative discount",
+			modify: func(r *CSVRecord) { r.Discount = "-0.1" },
+			want:   []string{"discount must be between 0 and 1, got -0.1"},
+		},
+		{
+			name:   "NaN unit price",
+			modify: func(r *CSVRecord) { r.UnitPrice = "NaN" },
+			want:   []string{`invalid unit_price "NaN"`},
+		},
+		{
+			name:   "infinite unit price",
+			modify: func(r *CSVRecord) { r.UnitPrice = "+Inf" },
+			want:   []string{`invalid unit_price "+Inf"`},
+		},
+		{
+			name:   "NaN discount",
+			modify: func(r *CSVRecord) { r.Discount = "nan" },
+			want:   []string{`invalid discount "nan"`},
+		},
+		{
+			name:   "infinite shipping cost",
+			modify: func(r *CSVRecord) { r.ShippingCost = "Infinity" },
+			want:   []string{`invalid shipping_cost "Infinity"`},
+		},
+		{
+			name:   "bad email",
+			modify: func(r *CSVRecord) { r.CustomerEmail = "ann.example.com" },
+			want:   []string{`invalid customer_email "ann.example.com"`},
+		},
+		{
+			name:   "email with display name",
+			modify: func(r *CSVRecord) { r.CustomerEmail = "Ann <ann@example.com>" },
+			want:   []string{`invalid customer_email "Ann <ann@example.com>"`},
+		},
+		{
+			name:   "bad date",
+			modify: func(r *CSVRecord) { r.DateOfSale = "10/01/2024" },
+			want:   []string{`invalid date_of_sale "10/01/2024", expected YYYY-MM-DD`},
+		},
+		{
+			name:   "zero quantity",
+			modify: func(r *CSVRecord) { r.QuantitySold = "0" },
+			want:   []string{"quantity_sold must be greater than 0, got 0"},
+		},
+		{
+			name: "every reason is reported",
+			modify: func(r *CSVRecord) {
+				r.OrderID = " "
+				r.UnitPrice = "-1"
+				r.ShippingCost = "free"
+			},
+			want: []string{
+				"order_id is required",
+				"unit_price must not be negative, got -1",
+				`invalid shipping_cost "free"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := validRecord()
+			tt.modify(&record)
+
+			parsed, reasons := ValidateRecord(record)
+			if !slices.Equal(reasons, tt.want) {
+				t.Fatalf("ValidateRecord() reasons = %q, want %q", reasons, tt.want)
+			}
+			if tt.want == nil && parsed.Order.OrderID != record.OrderID {
+				t.Errorf("order id = %q, want %q", parsed.Order.OrderID, record.OrderID)
+			}
+		})
+	}
+}
+
+func TestValidateRecordComputesLineRevenue(t *testing.T) {
+	parsed, reasons := ValidateRecord(validRecord())
+	if reasons != nil {
+		t.Fatalf("ValidateRecord() rejected a valid record: %q", reasons)
+	}
+	// 2 × (100 − 10%) = 180
+	if parsed.Order.LineRevenue != 180 {
+		t.Errorf("line revenue = %v, want 180", parsed.Order.LineRevenue)
+	}
+}
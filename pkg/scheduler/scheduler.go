@@ -2,34 +2,30 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
-	"sales_analytics/config"
-	"sales_analytics/pkg/repository"
+	"sales_analytics/pkg/refresh"
 
 	"github.com/robfig/cron/v3"
 )
 
 // Scheduler manages cron jobs for data refresh
 type Scheduler struct {
-	cron      *cron.Cron
-	jobID     cron.EntryID
-	jobLock   sync.Mutex
-	repo      *repository.MongoRepository
-	config    *config.Config
-	isRunning bool
+	cron    *cron.Cron
+	jobID   cron.EntryID
+	jobLock sync.Mutex
+	refresh *refresh.Coordinator
 }
 
 // NewScheduler creates a new scheduler instance
-func NewScheduler(repo *repository.MongoRepository, cfg *config.Config) *Scheduler {
+func NewScheduler(coord *refresh.Coordinator) *Scheduler {
 	return &Scheduler{
-		cron:      cron.New(),
-		repo:      repo,
-		config:    cfg,
-		isRunning: false,
+		cron:    cron.New(),
+		refresh: coord,
 	}
 }
 
@@ -118,34 +114,21 @@ func (s *Scheduler) GetJobStatus() map[string]interface{} {
 	return status
 }
 
-// executeDataRefresh performs the actual data refresh
+// executeDataRefresh starts a data refresh through the shared refresh coordinator,
+// which prevents overlap with manual refreshes and other replicas
 func (s *Scheduler) executeDataRefresh() {
-	// Prevent concurrent executions
-	s.jobLock.Lock()
-	if s.isRunning {
-		log.Println("Data refresh already running, skipping this execution")
-		s.jobLock.Unlock()
-		return
-	}
-	s.isRunning = true
-	s.jobLock.Unlock()
-
-	defer func() {
-		s.jobLock.Lock()
-		s.isRunning = false
-		s.jobLock.Unlock()
-	}()
-
 	log.Println("Cron job triggered: Starting data refresh...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	loader := repository.NewDataLoader(s.repo, s.config.WorkerPoolSize)
-
-	if err := loader.LoadCSV(ctx, s.config.CSVFilePath); err != nil {
-		log.Printf("Cron job failed: %v", err)
-	} else {
-		log.Println("Cron job completed successfully")
+	job, err := s.refresh.Start(ctx, "cron")
+	switch {
+	case errors.Is(err, refresh.ErrRefreshInProgress):
+		log.Printf("Data refresh %s already running, skipping this execution", job.ID.Hex())
+	case err != nil:
+		log.Printf("Cron job failed to start data refresh: %v", err)
+	default:
+		log.Printf("Cron job data refresh %s %s", job.ID.Hex(), job.Status)
 	}
 }